@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/zinrai/debinstaller-go/internal/config"
 	"github.com/zinrai/debinstaller-go/internal/installer"
@@ -13,22 +17,82 @@ import (
 
 func main() {
 	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
+	allowUnknownKeys := flag.Bool("allow-unknown-keys", false, "Ignore configuration keys that are not recognized")
+	planOnly := flag.Bool("plan", false, "Print the commands and files of the installation without executing anything")
+	resume := flag.Bool("resume", false, "Continue a failed installation from its first unfinished phase")
+	only := flag.String("only", "", "Comma-separated phases to run, skipping all others")
+	skip := flag.String("skip", "", "Comma-separated phases to skip")
 	flag.Parse()
 
-	cfg, err := config.LoadConfig(*configFile)
+	onlyPhases, skipPhases := splitList(*only), splitList(*skip)
+	if len(onlyPhases) > 0 && len(skipPhases) > 0 {
+		log.Fatalf("-only and -skip cannot be used together")
+	}
+	if *resume && (len(onlyPhases) > 0 || len(skipPhases) > 0) {
+		log.Fatalf("-resume cannot be combined with -only or -skip")
+	}
+	if err := installer.CheckPhaseNames(append(onlyPhases, skipPhases...)); err != nil {
+		log.Fatalf("Invalid phase selection: %v", err)
+	}
+
+	cfg, err := config.LoadConfig(*configFile, config.LoadOptions{
+		AllowUnknownKeys: *allowUnknownKeys,
+	})
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
 
 	logger := utils.NewLogger(cfg.LogFile)
 	defer logger.Close()
+	logger.AddSecret(cfg.Secrets()...)
+	for _, deprecation := range cfg.Storage.Deprecations() {
+		logger.Warn("%s", deprecation)
+	}
+
+	inst := installer.NewInstaller(cfg, logger, utils.NewExecRunner(logger))
+	inst.Resume = *resume
+	inst.Only = onlyPhases
+	inst.Skip = skipPhases
 
-	inst := installer.NewInstaller(cfg, logger)
+	// SIGINT and SIGTERM cancel the installation: running commands are
+	// terminated and the target is torn down before exiting. A second
+	// signal exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	if err := inst.Install(); err != nil {
+	if *planOnly {
+		plan, err := inst.Plan(ctx)
+		if err != nil {
+			logger.Error("Planning failed: %v", err)
+			os.Exit(1)
+		}
+		var out strings.Builder
+		if err := plan.Write(&out); err != nil {
+			logger.Error("Failed to print plan: %v", err)
+			os.Exit(1)
+		}
+		fmt.Print(logger.Redact(out.String()))
+		return
+	}
+
+	if err := inst.Install(ctx); err != nil {
 		logger.Error("Installation failed: %v", err)
 		os.Exit(1)
 	}
 
 	fmt.Println("Debian installation completed successfully")
 }
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -2,4 +2,4 @@ module github.com/zinrai/debinstaller-go
 
 go 1.23.1
 
-require gopkg.in/yaml.v2 v2.4.0
+require gopkg.in/yaml.v3 v3.0.1
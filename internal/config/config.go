@@ -1,8 +1,10 @@
 package config
 
 import (
-	"gopkg.in/yaml.v2"
 	"os"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
 )
 
 type PartitionType string
@@ -11,23 +13,95 @@ const (
 	PartitionTypeBiosBoot  PartitionType = "bios_boot"
 	PartitionTypeEfiSystem PartitionType = "efi_system"
 	PartitionTypeBoot      PartitionType = "boot"
+	PartitionTypeLinux     PartitionType = "linux" // any other filesystem, e.g. / without LVM
 	PartitionTypeLvmPV     PartitionType = "lvm_pv"
+	PartitionTypeRaid      PartitionType = "raid"
+	// Types of the Discoverable Partitions Specification, which
+	// systemd-gpt-auto-generator recognises.
+	PartitionTypeLinuxSwap      PartitionType = "linux_swap"
+	PartitionTypeLinuxHome      PartitionType = "linux_home"
+	PartitionTypeLinuxRootX8664 PartitionType = "linux_root_x86_64"
+	PartitionTypeLuks           PartitionType = "luks"
+	PartitionTypeXbootldr       PartitionType = "xbootldr"
+	PartitionTypeMsr            PartitionType = "msr" // Microsoft reserved
 )
 
+// FilesystemConfig describes the filesystem created on a partition, RAID
+// array or logical volume and where it is mounted. Filesystem "swap" sets
+// the device up as swap space instead.
+type FilesystemConfig struct {
+	Filesystem   string   `yaml:"filesystem,omitempty"`
+	MountPoint   string   `yaml:"mount_point,omitempty"`
+	MountOptions []string `yaml:"mount_options,omitempty"` // e.g. ["noatime", "nodev"]
+	Label        string   `yaml:"label,omitempty"`
+	MkfsOptions  []string `yaml:"mkfs_options,omitempty"` // extra mkfs arguments, e.g. ["-m", "1"]
+	// Resume makes the kernel resume from this swap space after
+	// hibernation.
+	Resume bool `yaml:"resume,omitempty"`
+	// Subvolumes are created on a btrfs filesystem and mounted in place of
+	// the top-level volume.
+	Subvolumes []Subvolume `yaml:"subvolumes,omitempty"`
+	// Format false keeps the existing volume and its data, which is only
+	// mounted. Defaults to true.
+	Format *bool `yaml:"format,omitempty"`
+}
+
+// configured reports whether any filesystem setting is present.
+func (fs FilesystemConfig) configured() bool {
+	return fs.Filesystem != "" || fs.MountPoint != "" || len(fs.MountOptions) > 0 ||
+		fs.Label != "" || len(fs.MkfsOptions) > 0 || fs.Resume || len(fs.Subvolumes) > 0 ||
+		fs.Format != nil
+}
+
+// Preserved reports whether the existing volume and its data are kept,
+// i.e. format is false.
+func (fs FilesystemConfig) Preserved() bool {
+	return fs.Format != nil && !*fs.Format
+}
+
+// mountedAt reports whether the filesystem or one of its subvolumes is
+// mounted at mountPoint.
+func (fs FilesystemConfig) mountedAt(mountPoint string) bool {
+	if fs.MountPoint == mountPoint {
+		return true
+	}
+	for _, sub := range fs.Subvolumes {
+		if sub.MountPoint == mountPoint {
+			return true
+		}
+	}
+	return false
+}
+
+// Subvolume is a btrfs subvolume, e.g. "@" mounted at / or "@home" at /home.
+type Subvolume struct {
+	Name         string   `yaml:"name"`
+	MountPoint   string   `yaml:"mount_point,omitempty"`
+	MountOptions []string `yaml:"mount_options,omitempty"`
+	Compression  string   `yaml:"compression,omitempty"` // e.g. "zstd" or "zstd:3"
+}
+
 type LogicalVolume struct {
-	Name       string `yaml:"name"`
-	Size       string `yaml:"size"`
-	Filesystem string `yaml:"filesystem"`
-	MountPoint string `yaml:"mount_point"`
+	Name             string `yaml:"name"`
+	Size             string `yaml:"size"` // e.g. "20G", "50%" of the VG, "remaining"
+	SizeBounds       `yaml:",inline"`
+	FilesystemConfig `yaml:",inline"`
 }
 
 type Partition struct {
-	Type           PartitionType   `yaml:"type"`
-	Size           string          `yaml:"size"`
-	Filesystem     string          `yaml:"filesystem,omitempty"`
-	MountPoint     string          `yaml:"mount_point,omitempty"`
-	VolumeGroup    string          `yaml:"volume_group,omitempty"`
-	LogicalVolumes []LogicalVolume `yaml:"logical_volumes,omitempty"`
+	Type             PartitionType `yaml:"type"`
+	Size             string        `yaml:"size"` // e.g. "512M", "50%" of the disk, "remaining"
+	SizeBounds       `yaml:",inline"`
+	FilesystemConfig `yaml:",inline"`
+	VolumeGroup      string          `yaml:"volume_group,omitempty"`
+	LogicalVolumes   []LogicalVolume `yaml:"logical_volumes,omitempty"`
+	RaidArray        string          `yaml:"raid_array,omitempty"` // for raid partitions
+	Encryption       *Encryption     `yaml:"encryption,omitempty"`
+	TypeGUID         string          `yaml:"type_guid,omitempty"`       // overrides the GPT type GUID of Type
+	PartitionLabel   string          `yaml:"partition_label,omitempty"` // GPT partition name
+	PartitionUUID    string          `yaml:"partition_uuid,omitempty"`  // GPT unique partition GUID
+	Attributes       []string        `yaml:"attributes,omitempty"`      // GPT attribute names or bit numbers
+	Bootable         bool            `yaml:"bootable,omitempty"`        // msdos boot flag
 }
 
 type NetworkConfig struct {
@@ -38,43 +112,115 @@ type NetworkConfig struct {
 	Gateway   string `yaml:"gateway,omitempty"`
 }
 
+// Credentials configures how an account logs in. At most one of Password,
+// PasswordHash or LockPassword may be set.
+type Credentials struct {
+	Password          string   `yaml:"password,omitempty"`
+	PasswordHash      string   `yaml:"password_hash,omitempty"` // crypt(3) string, e.g. "$y$..."
+	LockPassword      bool     `yaml:"lock_password,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+type User struct {
+	Username string `yaml:"username"`
+	// Exactly one of Password, PasswordHash or LockPassword must be set.
+	Credentials  `yaml:",inline"`
+	Groups       []string `yaml:"groups"`
+	UID          *int     `yaml:"uid,omitempty"`
+	PrimaryGroup string   `yaml:"primary_group,omitempty"`
+	Shell        string   `yaml:"shell,omitempty"` // defaults to /bin/bash
+	Home         string   `yaml:"home,omitempty"`  // defaults to /home/<username>
+	Comment      string   `yaml:"comment,omitempty"`
+	System       bool     `yaml:"system,omitempty"`
+	CreateHome   *bool    `yaml:"create_home,omitempty"` // defaults to true
+}
+
+// RootConfig configures the root account. Without a password setting the
+// root password is left as debootstrap created it.
+type RootConfig struct {
+	Credentials `yaml:",inline"`
+	// PermitSSHLogin is written to sshd_config.d as PermitRootLogin:
+	// "yes", "no", "prohibit-password" or "forced-commands-only".
+	PermitSSHLogin string `yaml:"permit_ssh_login,omitempty"`
+}
+
+// Group is created before any user is added, so users can reference it.
+type Group struct {
+	Name   string `yaml:"name"`
+	GID    *int   `yaml:"gid,omitempty"`
+	System bool   `yaml:"system,omitempty"`
+}
+
 type Config struct {
-	Storage struct {
-		Devices    []string `yaml:"devices"`
-		Bootloader struct {
-			Type string `yaml:"type"`
-		} `yaml:"bootloader"`
-		Partitions []Partition `yaml:"partitions"`
-	} `yaml:"storage"`
-	System struct {
+	Storage Storage `yaml:"storage"`
+	System  struct {
 		Hostname string `yaml:"hostname"`
 		Locale   string `yaml:"locale,omitempty"`
 	} `yaml:"system"`
-	Network NetworkConfig `yaml:"network"`
-	Users   []struct {
-		Username string   `yaml:"username"`
-		Password string   `yaml:"password"`
-		Groups   []string `yaml:"groups"`
-	} `yaml:"users"`
-	Packages     []string `yaml:"packages"`
+	Network      NetworkConfig `yaml:"network"`
+	Root         *RootConfig   `yaml:"root,omitempty"`
+	Groups       []Group       `yaml:"groups,omitempty"`
+	Users        []User        `yaml:"users"`
+	Packages     []string      `yaml:"packages"`
 	Installation struct {
 		MountPoint    string `yaml:"mount_point"`
 		Architecture  string `yaml:"architecture"`
 		DebianVersion string `yaml:"debian_version"`
 	} `yaml:"installation"`
-	LogFile string `yaml:"log_file"`
+	LogFile   string `yaml:"log_file"`
+	StateFile string `yaml:"state_file,omitempty"` // defaults to /tmp/debinstaller-state.json
+}
+
+// LoadOptions controls how LoadConfig decodes the configuration file.
+type LoadOptions struct {
+	// AllowUnknownKeys accepts keys that do not map to any configuration
+	// field, e.g. for configs written for a newer release.
+	AllowUnknownKeys bool
 }
 
-func LoadConfig(filename string) (*Config, error) {
+func LoadConfig(filename string, opts LoadOptions) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := root.Decode(&cfg); err != nil {
 		return nil, err
 	}
 
+	errs := cfg.validate()
+	if !opts.AllowUnknownKeys && len(root.Content) > 0 {
+		v := &validator{}
+		checkUnknownKeys(v, root.Content[0], reflect.TypeOf(cfg), "")
+		errs = append(v.errs, errs...)
+	}
+
+	if len(errs) > 0 {
+		errs.locate(filename, &root)
+		return nil, errs
+	}
+
 	return &cfg, nil
 }
+
+// Secrets returns every sensitive value in the configuration, such as user
+// passwords, so they can be kept out of logs.
+func (c *Config) Secrets() []string {
+	var secrets []string
+	if c.Root != nil {
+		secrets = append(secrets, c.Root.Password, c.Root.PasswordHash)
+	}
+	for _, user := range c.Users {
+		secrets = append(secrets, user.Password, user.PasswordHash)
+	}
+	for _, enc := range c.Storage.Encryptions() {
+		secrets = append(secrets, enc.Passphrase)
+	}
+	return secrets
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// partitionTypeGUIDs maps each partition type to its GPT partition type
+// GUID.
+var partitionTypeGUIDs = map[PartitionType]string{
+	PartitionTypeBiosBoot:       "21686148-6449-6E6F-744E-656564454649",
+	PartitionTypeEfiSystem:      "C12A7328-F81F-11D2-BA4B-00A0C93EC93B",
+	PartitionTypeBoot:           "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+	PartitionTypeLinux:          "0FC63DAF-8483-4772-8E79-3D69D8477DE4",
+	PartitionTypeLvmPV:          "E6D6D379-F507-44C2-A23C-238F2A3DF928",
+	PartitionTypeRaid:           "A19D880F-05FC-4D3B-A006-743F0F84911E",
+	PartitionTypeLinuxSwap:      "0657FD6D-A4AB-43C4-84E5-0933C84B4F4F",
+	PartitionTypeLinuxHome:      "933AC7E1-2EB4-4F13-B844-0E14E2AEF915",
+	PartitionTypeLinuxRootX8664: "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709",
+	PartitionTypeLuks:           "CA7D7CCB-63ED-4C53-861C-1742536059CC",
+	PartitionTypeXbootldr:       "BC13C2FF-59E6-4262-A352-B275FD6F7172",
+	PartitionTypeMsr:            "E3C9E316-0B5C-4DB8-817D-F92DF00215AE",
+}
+
+// partitionTypeMBRIDs maps the partition types available on msdos
+// partition tables to their MBR partition type ID.
+var partitionTypeMBRIDs = map[PartitionType]string{
+	PartitionTypeEfiSystem:      "ef",
+	PartitionTypeBoot:           "83",
+	PartitionTypeLinux:          "83",
+	PartitionTypeLvmPV:          "8e",
+	PartitionTypeRaid:           "fd",
+	PartitionTypeLinuxSwap:      "82",
+	PartitionTypeLinuxHome:      "83",
+	PartitionTypeLinuxRootX8664: "83",
+	PartitionTypeLuks:           "83",
+	PartitionTypeXbootldr:       "ea",
+}
+
+// gptAttributes names the GPT attribute bits, including those of the
+// Discoverable Partitions Specification.
+var gptAttributes = map[string]int{
+	"required":    0,
+	"no_block_io": 1,
+	"legacy_boot": 2,
+	"growfs":      59,
+	"read_only":   60,
+	"no_auto":     63,
+}
+
+// GPTTypeGUID returns the GPT partition type GUID of the partition: its
+// TypeGUID if set, otherwise the GUID of its Type.
+func (p Partition) GPTTypeGUID() string {
+	if p.TypeGUID != "" {
+		return p.TypeGUID
+	}
+	return partitionTypeGUIDs[p.Type]
+}
+
+// MBRTypeID returns the MBR partition type ID of the partition, or "" if
+// its type only exists on GPT.
+func (p Partition) MBRTypeID() string {
+	return partitionTypeMBRIDs[p.Type]
+}
+
+// ParseGPTAttribute returns the bit number of a GPT attribute given by name,
+// e.g. "legacy_boot", or as a number from 0 to 63.
+func ParseGPTAttribute(attr string) (int, error) {
+	if bit, ok := gptAttributes[attr]; ok {
+		return bit, nil
+	}
+	bit, err := strconv.Atoi(attr)
+	if err != nil || bit < 0 || bit > 63 {
+		return 0, fmt.Errorf("unknown GPT attribute %q (expected a bit number from 0 to 63 or one of required, no_block_io, legacy_boot, growfs, read_only, no_auto)", attr)
+	}
+	return bit, nil
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps unit suffixes to bytes. Single letters and IEC suffixes are
+// binary, as in sgdisk and lvcreate; SI suffixes are decimal. There is no
+// default unit, since sgdisk reads bare numbers as sectors and lvcreate as
+// MiB.
+var sizeUnits = map[string]uint64{
+	"B":   1,
+	"K":   1 << 10,
+	"M":   1 << 20,
+	"G":   1 << 30,
+	"T":   1 << 40,
+	"P":   1 << 50,
+	"KIB": 1 << 10,
+	"MIB": 1 << 20,
+	"GIB": 1 << 30,
+	"TIB": 1 << 40,
+	"PIB": 1 << 50,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"PB":  1e15,
+}
+
+// ParseSize parses sizes such as "512M", "1.5G", "512MiB" or "10GB" and
+// returns the size in bytes. Bytes are written with a "B" suffix.
+func ParseSize(s string) (uint64, error) {
+	value := strings.TrimSpace(s)
+	if value == "" {
+		return 0, fmt.Errorf("size is empty")
+	}
+
+	split := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		return 0, fmt.Errorf("invalid size %q: a unit is required, e.g. %sM", s, value)
+	}
+	unit := strings.ToUpper(value[split:])
+	value = value[:split]
+
+	multiplier, ok := sizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, unit)
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number <= 0 || number*float64(multiplier) > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	bytes := uint64(math.Round(number * float64(multiplier)))
+	if bytes == 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return bytes, nil
+}
+
+// FormatSize renders bytes in the largest binary unit that represents it
+// exactly, e.g. "512M", as understood by sgdisk, lvcreate and mount.
+// Sizes that are not whole KiB are rounded up.
+func FormatSize(bytes uint64) string {
+	kib := (bytes + 1<<10 - 1) >> 10
+	for _, unit := range []string{"K", "M", "G", "T"} {
+		if kib%1024 != 0 {
+			return fmt.Sprintf("%d%s", kib, unit)
+		}
+		kib /= 1024
+	}
+	return fmt.Sprintf("%dP", kib)
+}
+
+// SizeKind tells what a Size is measured against.
+type SizeKind int
+
+const (
+	// SizeAbsolute is a fixed number of bytes.
+	SizeAbsolute SizeKind = iota
+	// SizePercent is a share of the whole disk or volume group.
+	SizePercent
+	// SizePercentFree is a share of the space the absolute and SizePercent
+	// sizes of the disk or volume group leave free.
+	SizePercentFree
+)
+
+// Size is a partition or logical volume size.
+type Size struct {
+	Kind    SizeKind
+	Bytes   uint64 // for SizeAbsolute
+	Percent uint64 // for SizePercent and SizePercentFree, 1 to 100
+}
+
+// ParseSizeSpec parses a partition or logical volume size: an absolute size
+// accepted by ParseSize, "N%" of the disk or volume group, "N%FREE" of the
+// space left free, or "remaining", which is "100%FREE".
+func ParseSizeSpec(s string) (Size, error) {
+	value := strings.TrimSpace(s)
+	if strings.EqualFold(value, "remaining") {
+		return Size{Kind: SizePercentFree, Percent: 100}, nil
+	}
+
+	idx := strings.Index(value, "%")
+	if idx < 0 {
+		bytes, err := ParseSize(value)
+		if err != nil {
+			return Size{}, err
+		}
+		return Size{Kind: SizeAbsolute, Bytes: bytes}, nil
+	}
+
+	size := Size{Kind: SizePercent}
+	switch strings.ToUpper(value[idx+1:]) {
+	case "":
+	case "FREE":
+		size.Kind = SizePercentFree
+	default:
+		return Size{}, fmt.Errorf("invalid size %q (expected N%% or N%%FREE)", s)
+	}
+
+	percent, err := strconv.ParseUint(value[:idx], 10, 64)
+	if err != nil || percent == 0 || percent > 100 {
+		return Size{}, fmt.Errorf("invalid size %q: percentage must be between 1 and 100", s)
+	}
+	size.Percent = percent
+	return size, nil
+}
+
+// Relative reports whether the size depends on the disk or volume group.
+func (s Size) Relative() bool {
+	return s.Kind != SizeAbsolute
+}
+
+func (s Size) String() string {
+	switch s.Kind {
+	case SizePercent:
+		return fmt.Sprintf("%d%%", s.Percent)
+	case SizePercentFree:
+		return fmt.Sprintf("%d%%FREE", s.Percent)
+	default:
+		return FormatSize(s.Bytes)
+	}
+}
+
+// SizeBounds limits a percentage size to a range of absolute sizes.
+type SizeBounds struct {
+	MinSize string `yaml:"min_size,omitempty"`
+	MaxSize string `yaml:"max_size,omitempty"`
+}
+
+// Clamp applies the bounds to bytes. The bounds must have been validated.
+func (b SizeBounds) Clamp(bytes uint64) uint64 {
+	if minimum, err := ParseSize(b.MinSize); err == nil && bytes < minimum {
+		bytes = minimum
+	}
+	if maximum, err := ParseSize(b.MaxSize); err == nil && bytes > maximum {
+		bytes = maximum
+	}
+	return bytes
+}
+
+// Set reports whether any bound is configured.
+func (b SizeBounds) Set() bool {
+	return b.MinSize != "" || b.MaxSize != ""
+}
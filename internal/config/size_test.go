@@ -0,0 +1,151 @@
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{in: "512B", want: 512},
+		{in: "1K", want: 1 << 10},
+		{in: "512M", want: 512 << 20},
+		{in: "512m", want: 512 << 20},
+		{in: "1.5G", want: 3 << 29},
+		{in: "512MiB", want: 512 << 20},
+		{in: "2T", want: 2 << 40},
+		{in: "10GB", want: 10e9},
+		{in: "1kb", want: 1000},
+		{in: " 4G ", want: 4 << 30},
+		{in: "", wantErr: true},
+		{in: "512", wantErr: true},
+		{in: "1.5", wantErr: true},
+		{in: "G", wantErr: true},
+		{in: "0M", wantErr: true},
+		{in: "0.1B", wantErr: true},
+		{in: "-1G", wantErr: true},
+		{in: "1X", wantErr: true},
+		{in: "1.2.3G", wantErr: true},
+		{in: "100000P", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSize(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		in   uint64
+		want string
+	}{
+		{in: 1, want: "1K"},
+		{in: 1 << 10, want: "1K"},
+		{in: 1<<10 + 1, want: "2K"},
+		{in: 1536 << 10, want: "1536K"},
+		{in: 512 << 20, want: "512M"},
+		{in: 3 << 29, want: "1536M"},
+		{in: 4 << 30, want: "4G"},
+		{in: 10e9, want: "9765625K"},
+		{in: 2 << 40, want: "2T"},
+		{in: 3 << 50, want: "3P"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatSize(tt.in); got != tt.want {
+			t.Errorf("FormatSize(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseSizeSpec(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Size
+		wantErr bool
+	}{
+		{in: "20G", want: Size{Kind: SizeAbsolute, Bytes: 20 << 30}},
+		{in: "50%", want: Size{Kind: SizePercent, Percent: 50}},
+		{in: "100%", want: Size{Kind: SizePercent, Percent: 100}},
+		{in: "30%FREE", want: Size{Kind: SizePercentFree, Percent: 30}},
+		{in: "30%free", want: Size{Kind: SizePercentFree, Percent: 30}},
+		{in: "remaining", want: Size{Kind: SizePercentFree, Percent: 100}},
+		{in: "Remaining", want: Size{Kind: SizePercentFree, Percent: 100}},
+		{in: "20", wantErr: true},
+		{in: "0%", wantErr: true},
+		{in: "101%", wantErr: true},
+		{in: "1.5%", wantErr: true},
+		{in: "%", wantErr: true},
+		{in: "50%VG", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSizeSpec(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSizeSpec(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSizeSpec(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSizeSpec(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSizeString(t *testing.T) {
+	tests := []struct {
+		in   Size
+		want string
+	}{
+		{in: Size{Kind: SizeAbsolute, Bytes: 512 << 20}, want: "512M"},
+		{in: Size{Kind: SizePercent, Percent: 50}, want: "50%"},
+		{in: Size{Kind: SizePercentFree, Percent: 100}, want: "100%FREE"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSizeBoundsClamp(t *testing.T) {
+	bounds := SizeBounds{MinSize: "1G", MaxSize: "4G"}
+	tests := []struct {
+		in   uint64
+		want uint64
+	}{
+		{in: 512 << 20, want: 1 << 30},
+		{in: 2 << 30, want: 2 << 30},
+		{in: 8 << 30, want: 4 << 30},
+	}
+
+	for _, tt := range tests {
+		if got := bounds.Clamp(tt.in); got != tt.want {
+			t.Errorf("Clamp(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+	if got := (SizeBounds{}).Clamp(123); got != 123 {
+		t.Errorf("Clamp without bounds = %d, want 123", got)
+	}
+}
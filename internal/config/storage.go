@@ -0,0 +1,252 @@
+package config
+
+type Storage struct {
+	// Devices and Partitions describe the single-disk layout of earlier
+	// releases. Disks replaces them and cannot be combined with them.
+	Devices    []string    `yaml:"devices,omitempty"`
+	Partitions []Partition `yaml:"partitions,omitempty"`
+
+	Disks        []Disk        `yaml:"disks,omitempty"`
+	Raid         []RaidArray   `yaml:"raid,omitempty"`
+	VolumeGroups []VolumeGroup `yaml:"volume_groups,omitempty"`
+	Tmpfs        []Tmpfs       `yaml:"tmpfs,omitempty"`
+	Swap         *Swap         `yaml:"swap,omitempty"`
+	Bootloader   struct {
+		Type string `yaml:"type"`
+	} `yaml:"bootloader"`
+}
+
+// Disk is a block device with its own partition table, or, for data
+// drives, a filesystem on the whole device.
+type Disk struct {
+	Device           string      `yaml:"device"`
+	Preserve         bool        `yaml:"preserve,omitempty"` // keep the existing partition table
+	Table            string      `yaml:"table,omitempty"`    // "gpt" (default) or "msdos"
+	Partitions       []Partition `yaml:"partitions,omitempty"`
+	FilesystemConfig `yaml:",inline"`
+}
+
+// RaidArray is an md software RAID device. Its members are the raid
+// partitions naming it in raid_array, usually one on each disk. The array
+// either carries a filesystem or is a physical volume of VolumeGroup.
+type RaidArray struct {
+	Name             string `yaml:"name"`               // md device name, e.g. "md0"
+	Level            string `yaml:"level"`              // 0, 1, 5, 6 or 10
+	Metadata         string `yaml:"metadata,omitempty"` // superblock format, defaults to 1.2
+	FilesystemConfig `yaml:",inline"`
+	VolumeGroup      string      `yaml:"volume_group,omitempty"`
+	Encryption       *Encryption `yaml:"encryption,omitempty"`
+}
+
+// Encryption wraps a partition or RAID array in LUKS2. The filesystem or
+// physical volume is created on the unlocked device /dev/mapper/<Name>.
+// Exactly one of Passphrase or Keyfile must be set.
+type Encryption struct {
+	Name       string `yaml:"name"`
+	Passphrase string `yaml:"passphrase,omitempty"` // asked for at boot
+	Keyfile    string `yaml:"keyfile,omitempty"`    // path on the installation host, copied into the target
+}
+
+// VolumeGroup defines the logical volumes of a volume group. Its physical
+// volumes are the lvm_pv partitions and RAID arrays naming it in
+// volume_group, which may be spread over several disks.
+type VolumeGroup struct {
+	Name           string          `yaml:"name"`
+	LogicalVolumes []LogicalVolume `yaml:"logical_volumes"`
+	// Preserve keeps the existing volume group and its logical volumes with
+	// format false. The others are created anew.
+	Preserve bool `yaml:"preserve,omitempty"`
+}
+
+// Tmpfs is a memory-backed filesystem mounted by the installed system.
+type Tmpfs struct {
+	MountPoint   string   `yaml:"mount_point"`
+	Size         string   `yaml:"size,omitempty"` // e.g. "2G" or "50%" of RAM
+	MountOptions []string `yaml:"mount_options,omitempty"`
+}
+
+// Swap configures swap space that does not have a partition or logical
+// volume of its own.
+type Swap struct {
+	File *SwapFile `yaml:"file,omitempty"`
+	Zram *Zram     `yaml:"zram,omitempty"`
+}
+
+// SwapFile is a swap file created in one of the mounted filesystems.
+type SwapFile struct {
+	Path string `yaml:"path"`
+	Size string `yaml:"size"`
+}
+
+// Zram configures compressed swap in RAM through zram-generator.
+type Zram struct {
+	Size        string `yaml:"size,omitempty"`        // zram-generator expression, e.g. "ram / 2"
+	Compression string `yaml:"compression,omitempty"` // e.g. "zstd"
+}
+
+// DiskLayouts returns every disk with its partitions, converting the legacy
+// devices/partitions form. As before storage.disks existed, every legacy
+// device gets the same partition table, but only the first one holds the
+// filesystems and volume groups.
+func (s *Storage) DiskLayouts() []Disk {
+	if len(s.Disks) > 0 {
+		return s.Disks
+	}
+
+	var disks []Disk
+	for idx, device := range s.Devices {
+		partitions := s.Partitions
+		if idx > 0 {
+			partitions = make([]Partition, len(s.Partitions))
+			for partIdx, part := range s.Partitions {
+				partitions[partIdx] = Partition{Type: part.Type, Size: part.Size}
+			}
+		}
+		disks = append(disks, Disk{Device: device, Partitions: partitions})
+	}
+	return disks
+}
+
+// Deprecations describes the deprecated settings in use and how to replace
+// them.
+func (s *Storage) Deprecations() []string {
+	if len(s.Devices) > 1 {
+		return []string{"storage.devices with more than one device is deprecated; " +
+			"list each device under storage.disks with its own partitions instead"}
+	}
+	return nil
+}
+
+// Partition table formats of Disk.Table.
+const (
+	TableGPT   = "gpt"
+	TableMsdos = "msdos"
+)
+
+// MsdosPrimaryLimit is the number of primary partitions of an msdos
+// partition table. Disks with more partitions keep the first three as
+// primary partitions and the others as logical partitions in an extended
+// partition, numbered from 5.
+const MsdosPrimaryLimit = 4
+
+// LogicalPartition reports whether the partition at idx of d is a logical
+// partition of an msdos table.
+func (d Disk) LogicalPartition(idx int) bool {
+	return d.Table == TableMsdos && len(d.Partitions) > MsdosPrimaryLimit && idx >= MsdosPrimaryLimit-1
+}
+
+// Encryptions returns the encryption settings of every encrypted partition
+// and RAID array.
+func (s *Storage) Encryptions() []*Encryption {
+	var encryptions []*Encryption
+	for _, disk := range s.DiskLayouts() {
+		for _, part := range disk.Partitions {
+			if part.Encryption != nil {
+				encryptions = append(encryptions, part.Encryption)
+			}
+		}
+	}
+	for _, array := range s.Raid {
+		if array.Encryption != nil {
+			encryptions = append(encryptions, array.Encryption)
+		}
+	}
+	return encryptions
+}
+
+// VolumeGroupLayouts returns every volume group in the order it is first
+// referenced by an lvm_pv partition or RAID array, with the logical volumes
+// defined in volume_groups and those defined inline on its lvm_pv
+// partitions.
+func (s *Storage) VolumeGroupLayouts() []VolumeGroup {
+	var groups []VolumeGroup
+	index := make(map[string]int)
+
+	add := func(name string, lvs []LogicalVolume, preserve bool) {
+		idx, ok := index[name]
+		if !ok {
+			idx = len(groups)
+			index[name] = idx
+			groups = append(groups, VolumeGroup{Name: name})
+		}
+		groups[idx].LogicalVolumes = append(groups[idx].LogicalVolumes, lvs...)
+		groups[idx].Preserve = groups[idx].Preserve || preserve
+	}
+
+	for _, disk := range s.DiskLayouts() {
+		for _, part := range disk.Partitions {
+			if part.Type == PartitionTypeLvmPV && part.VolumeGroup != "" {
+				add(part.VolumeGroup, part.LogicalVolumes, false)
+			}
+		}
+	}
+	for _, array := range s.Raid {
+		if array.VolumeGroup != "" {
+			add(array.VolumeGroup, nil, false)
+		}
+	}
+	for _, vg := range s.VolumeGroups {
+		add(vg.Name, vg.LogicalVolumes, vg.Preserve)
+	}
+
+	return groups
+}
+
+// BootRaidArrays returns the names of the RAID arrays holding the filesystem
+// mounted at /boot, or at / when there is no separate /boot, directly or as
+// physical volumes. GRUB has to be installed on every disk with members of
+// these arrays for BIOS boot.
+func (s *Storage) BootRaidArrays() []string {
+	boot := s.bootMountPoint()
+	bootGroups := s.bootVolumeGroups()
+
+	var names []string
+	for _, array := range s.Raid {
+		if array.mountedAt(boot) || (array.VolumeGroup != "" && bootGroups[array.VolumeGroup]) {
+			names = append(names, array.Name)
+		}
+	}
+	return names
+}
+
+// bootMountPoint returns the mount point of the filesystem GRUB reads the
+// kernel from: /boot, or / when there is no separate /boot.
+func (s *Storage) bootMountPoint() string {
+	var filesystems []FilesystemConfig
+	for _, disk := range s.DiskLayouts() {
+		filesystems = append(filesystems, disk.FilesystemConfig)
+		for _, part := range disk.Partitions {
+			filesystems = append(filesystems, part.FilesystemConfig)
+		}
+	}
+	for _, array := range s.Raid {
+		filesystems = append(filesystems, array.FilesystemConfig)
+	}
+	for _, vg := range s.VolumeGroupLayouts() {
+		for _, lv := range vg.LogicalVolumes {
+			filesystems = append(filesystems, lv.FilesystemConfig)
+		}
+	}
+
+	for _, fs := range filesystems {
+		if fs.mountedAt("/boot") {
+			return "/boot"
+		}
+	}
+	return "/"
+}
+
+// bootVolumeGroups returns the names of the volume groups with a logical
+// volume mounted at the boot mount point.
+func (s *Storage) bootVolumeGroups() map[string]bool {
+	boot := s.bootMountPoint()
+	groups := make(map[string]bool)
+	for _, vg := range s.VolumeGroupLayouts() {
+		for _, lv := range vg.LogicalVolumes {
+			if lv.mountedAt(boot) {
+				groups[vg.Name] = true
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkUnknownKeys reports every mapping key in node that does not
+// correspond to a field of t. Misspelled keys would otherwise be dropped
+// silently and leave the matching field empty. Aliases and "<<" merge keys
+// are followed as the decoder does.
+func checkUnknownKeys(v *validator, node *yaml.Node, t reflect.Type, path string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, value := node.Content[idx], node.Content[idx+1]
+			if isMergeKey(key) {
+				checkMerged(v, value, t, path)
+				continue
+			}
+			child := key.Value
+			if path != "" {
+				child = path + "." + key.Value
+			}
+
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				v.addf(child, "unknown key %q", key.Value)
+				continue
+			}
+			checkUnknownKeys(v, value, fieldType, child)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for idx, item := range node.Content {
+			checkUnknownKeys(v, item, t.Elem(), fmt.Sprintf("%s[%d]", path, idx))
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, value := node.Content[idx], node.Content[idx+1]
+			if isMergeKey(key) {
+				checkMerged(v, value, t, path)
+				continue
+			}
+			checkUnknownKeys(v, value, t.Elem(), path+"."+key.Value)
+		}
+	}
+}
+
+// isMergeKey reports whether key is a "<<" merge key.
+func isMergeKey(key *yaml.Node) bool {
+	return key.Kind == yaml.ScalarNode && key.ShortTag() == "!!merge"
+}
+
+// checkMerged checks the keys merged into a mapping of type t: the value of
+// a merge key is a mapping, an alias of one, or a sequence of those.
+func checkMerged(v *validator, value *yaml.Node, t reflect.Type, path string) {
+	for value.Kind == yaml.AliasNode {
+		value = value.Alias
+	}
+	if value.Kind == yaml.SequenceNode {
+		for _, item := range value.Content {
+			checkUnknownKeys(v, item, t, path)
+		}
+		return
+	}
+	checkUnknownKeys(v, value, t, path)
+}
+
+// yamlFields maps the YAML key of every field of t, including fields of
+// inlined structs, to the field type.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+
+		name, flags, _ := strings.Cut(tag, ",")
+		if strings.Contains(flags, "inline") && field.Type.Kind() == reflect.Struct {
+			for key, fieldType := range yamlFields(field.Type) {
+				fields[key] = fieldType
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
@@ -0,0 +1,127 @@
+package config
+
+import "testing"
+
+func TestCheckUnknownKeys(t *testing.T) {
+	const devices = `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "bios"
+  partitions:
+`
+
+	tests := []struct {
+		name       string
+		partitions string
+		want       []string
+	}{
+		{
+			name: "misspelled key",
+			partitions: `    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mountpoint: "/"
+`,
+			want: []string{
+				`12 storage.partitions[1].mountpoint: unknown key "mountpoint"`,
+				`1 storage: no filesystem is mounted at /`,
+			},
+		},
+		{
+			name: "unknown key in inlined fields",
+			partitions: `    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "remaining"
+      min_sise: "1G"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+			want: []string{`11 storage.partitions[1].min_sise: unknown key "min_sise"`},
+		},
+		{
+			name: "merge key",
+			partitions: `    - &boot
+      type: "bios_boot"
+      size: "2M"
+    - <<: *boot
+      type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+		},
+		{
+			name: "merge key with a sequence of aliases",
+			partitions: `    - &boot
+      type: "bios_boot"
+      size: "2M"
+    - &root
+      type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+    - <<: [*boot, *root]
+      size: "5G"
+      mount_point: "/srv"
+`,
+			want: []string{
+				`15 storage.partitions[2]: bios_boot partition must not have a filesystem or mount point`,
+			},
+		},
+		{
+			name: "unknown key brought in by an alias",
+			partitions: `    - type: "bios_boot"
+      size: "2M"
+    - &root
+      type: "boot"
+      size: "10G"
+      filesytem: "ext4"
+      mount_point: "/"
+    - <<: *root
+      size: "5G"
+      mount_point: "/srv"
+`,
+			want: []string{
+				`12 storage.partitions[1].filesytem: unknown key "filesytem"`,
+				`14 storage.partitions[2].filesytem: unknown key "filesytem"`,
+				`13 storage.partitions[1].mount_point: mount point "/" requires a filesystem`,
+				`16 storage.partitions[2].mount_point: mount point "/srv" requires a filesystem`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadYAML(t, devices+tt.partitions+commonConfig, LoadOptions{})
+			wantErrors(t, err, tt.want)
+		})
+	}
+}
+
+func TestAllowUnknownKeys(t *testing.T) {
+	content := `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "bios"
+  partitions:
+    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+      future_option: true
+` + commonConfig
+
+	if _, err := loadYAML(t, content, LoadOptions{AllowUnknownKeys: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := loadYAML(t, content, LoadOptions{}); err == nil {
+		t.Error("unknown key accepted without AllowUnknownKeys")
+	}
+}
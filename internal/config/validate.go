@@ -0,0 +1,1169 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ValidationError describes a single problem found in the configuration.
+// Path is the YAML path of the offending value, e.g.
+// "storage.partitions[1].type".
+type ValidationError struct {
+	File    string
+	Line    int
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors collects every problem found in the configuration so they
+// can be reported together.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for idx, err := range errs {
+		msgs[idx] = err.Error()
+	}
+	return fmt.Sprintf("%d configuration error(s):\n  %s", len(errs), strings.Join(msgs, "\n  "))
+}
+
+// locate fills in the file name and line number of each error using the
+// parsed YAML document. Errors for missing keys are reported at the line of
+// the closest enclosing value.
+func (errs ValidationErrors) locate(filename string, root *yaml.Node) {
+	lines := make(map[string]int)
+	if len(root.Content) > 0 {
+		indexLines(root.Content[0], "", lines)
+	}
+
+	for _, err := range errs {
+		err.File = filename
+		for path := err.Path; ; path = parentPath(path) {
+			if line, ok := lines[path]; ok {
+				err.Line = line
+				break
+			}
+			if path == "" {
+				break
+			}
+		}
+	}
+}
+
+func indexLines(node *yaml.Node, path string, lines map[string]int) {
+	lines[path] = node.Line
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, value := node.Content[idx], node.Content[idx+1]
+			child := key.Value
+			if path != "" {
+				child = path + "." + key.Value
+			}
+			indexLines(value, child, lines)
+			lines[child] = key.Line
+		}
+	case yaml.SequenceNode:
+		for idx, item := range node.Content {
+			indexLines(item, fmt.Sprintf("%s[%d]", path, idx), lines)
+		}
+	}
+}
+
+func parentPath(path string) string {
+	if idx := strings.LastIndexAny(path, ".["); idx >= 0 {
+		return path[:idx]
+	}
+	return ""
+}
+
+// Validate checks the configuration for semantic errors. It returns
+// ValidationErrors listing every problem found, or nil.
+func (c *Config) Validate() error {
+	if errs := c.validate(); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) addf(path string, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+var (
+	// LVM allows letters, digits and "+_.-" in VG and LV names.
+	lvmNamePattern  = regexp.MustCompile(`^[a-zA-Z0-9+_.][a-zA-Z0-9+_.-]*$`)
+	usernamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]*\$?$`)
+	hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+	// tmpfs size= as a share of RAM.
+	tmpfsPercentPattern = regexp.MustCompile(`^[1-9][0-9]*%$`)
+	// btrfs compress= values.
+	compressionPattern = regexp.MustCompile(`^(zlib|lzo|zstd)(:[0-9]+)?$`)
+	// device-mapper names, which become /dev/mapper/<name>.
+	mappingNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.+-]+$`)
+	// GPT type and partition GUIDs.
+	guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	// md device names as created under /dev.
+	raidNamePattern = regexp.MustCompile(`^md[0-9]+$`)
+	// Modular crypt format, e.g. "$y$j9T$salt$hash" or "$6$salt$hash".
+	passwordHashPattern = regexp.MustCompile(`^\$[0-9a-z]+\$[^:\s]+$`)
+)
+
+// raidMinMembers is the number of members each supported RAID level needs.
+var raidMinMembers = map[string]int{
+	"0":  2,
+	"1":  2,
+	"5":  3,
+	"6":  4,
+	"10": 2,
+}
+
+var raidMetadataFormats = map[string]bool{
+	"0.90": true,
+	"1.0":  true,
+	"1.1":  true,
+	"1.2":  true,
+}
+
+// zramCompression lists the compression algorithms of the zram driver.
+var zramCompression = map[string]bool{
+	"lzo":     true,
+	"lzo-rle": true,
+	"lz4":     true,
+	"lz4hc":   true,
+	"zstd":    true,
+	"deflate": true,
+	"842":     true,
+}
+
+// labelLimits is the maximum label length of each filesystem.
+var labelLimits = map[string]int{
+	"ext2":  16,
+	"ext3":  16,
+	"ext4":  16,
+	"xfs":   12,
+	"btrfs": 255,
+	"vfat":  11,
+	"swap":  16,
+}
+
+var supportedFilesystems = map[string]bool{
+	"ext2":  true,
+	"ext3":  true,
+	"ext4":  true,
+	"xfs":   true,
+	"btrfs": true,
+	"vfat":  true,
+	"swap":  true,
+}
+
+func (c *Config) validate() ValidationErrors {
+	v := &validator{}
+
+	c.validateStorage(v)
+	c.validateSystem(v)
+	c.validateNetwork(v)
+	c.validateRoot(v)
+	c.validateGroups(v)
+	c.validateUsers(v)
+	c.validateInstallation(v)
+
+	if c.LogFile == "" {
+		v.addf("log_file", "log file is required")
+	}
+
+	return v.errs
+}
+
+// storageValidator carries what has been seen so far while walking the
+// storage section, for checks that span disks.
+type storageValidator struct {
+	*validator
+	mountPoints map[string]string
+	hasType     map[PartitionType]bool
+	efiMounted  bool
+	// pvs maps each volume group to the paths of its lvm_pv partitions and
+	// RAID arrays.
+	pvs map[string][]string
+	// raidMembers maps each RAID array to the paths of its member partitions.
+	raidMembers map[string][]string
+	// mappings maps each LUKS mapping name to the path defining it.
+	mappings map[string]string
+	// resume is the path of the swap space marked for resume, if any.
+	resume string
+	// lvs maps each volume group to the paths of its logical volumes by name.
+	lvs map[string]map[string]string
+	// partitionUUIDs maps each GPT partition GUID, in upper case, to the
+	// path of the partition using it.
+	partitionUUIDs map[string]string
+	// boot is the mount point GRUB reads the kernel from and bootGroups
+	// the volume groups holding it.
+	boot       string
+	bootGroups map[string]bool
+	// table is the partition table of the disk being walked.
+	table string
+	// logical is set while walking a logical partition of an msdos table.
+	logical bool
+	// diskBootable is the path of the partition with the msdos boot flag on
+	// the disk being walked, if any.
+	diskBootable string
+	// preserveDisk is set while walking a disk whose partition table is kept.
+	preserveDisk bool
+	// kept lists the partitions on disks whose partition table is kept.
+	kept map[string]bool
+	// preservedVGs lists the volume groups that are kept.
+	preservedVGs map[string]bool
+	// bootable is set once a partition with the msdos boot flag is seen.
+	bootable bool
+	// disk adds up the percentage sizes of the disk being walked.
+	disk *shares
+	// vgShares adds up the percentage sizes of each volume group.
+	vgShares map[string]*shares
+}
+
+// shares adds up the percentage sizes within a disk or volume group, which
+// must not exceed the whole.
+type shares struct {
+	of      string
+	percent uint64
+	free    uint64
+}
+
+func (c *Config) validateStorage(v *validator) {
+	storage := &c.Storage
+	sv := &storageValidator{
+		validator:      v,
+		mountPoints:    make(map[string]string),
+		hasType:        make(map[PartitionType]bool),
+		pvs:            make(map[string][]string),
+		raidMembers:    make(map[string][]string),
+		mappings:       make(map[string]string),
+		lvs:            make(map[string]map[string]string),
+		partitionUUIDs: make(map[string]string),
+		boot:           storage.bootMountPoint(),
+		bootGroups:     storage.bootVolumeGroups(),
+		vgShares:       make(map[string]*shares),
+		kept:           make(map[string]bool),
+		preservedVGs:   make(map[string]bool),
+	}
+	for _, vg := range storage.VolumeGroups {
+		if vg.Preserve {
+			sv.preservedVGs[vg.Name] = true
+		}
+	}
+
+	legacy := len(storage.Devices) > 0 || len(storage.Partitions) > 0
+	switch {
+	case legacy && len(storage.Disks) > 0:
+		v.addf("storage.disks", "storage.disks cannot be combined with storage.devices and storage.partitions")
+	case legacy:
+		if len(storage.Devices) == 0 {
+			v.addf("storage.devices", "at least one device is required")
+		}
+		for idx, device := range storage.Devices {
+			sv.checkDevice(fmt.Sprintf("storage.devices[%d]", idx), device)
+		}
+		if len(storage.Partitions) == 0 {
+			v.addf("storage.partitions", "at least one partition is required")
+		}
+		sv.disk = &shares{of: "the device"}
+		for idx, part := range storage.Partitions {
+			sv.checkPartition(fmt.Sprintf("storage.partitions[%d]", idx), part)
+		}
+	case len(storage.Disks) == 0:
+		v.addf("storage.disks", "at least one disk is required")
+	}
+
+	bootArrays := make(map[string]bool)
+	for _, name := range storage.BootRaidArrays() {
+		bootArrays[name] = true
+	}
+
+	devices := make(map[string]string)
+	for diskIdx, disk := range storage.Disks {
+		path := fmt.Sprintf("storage.disks[%d]", diskIdx)
+
+		sv.checkDevice(path+".device", disk.Device)
+		if other, ok := devices[disk.Device]; ok && disk.Device != "" {
+			v.addf(path+".device", "device %q is already used by %s", disk.Device, other)
+		}
+		devices[disk.Device] = path
+
+		switch {
+		case len(disk.Partitions) > 0 && disk.FilesystemConfig.configured():
+			v.addf(path, "disk %q must have either partitions or a filesystem on the whole disk", disk.Device)
+		case disk.FilesystemConfig.configured():
+			if disk.Filesystem == "" {
+				v.addf(path+".filesystem", "filesystem is required")
+			} else if disk.MountPoint == "" && len(disk.Subvolumes) == 0 && disk.Filesystem != "swap" {
+				v.addf(path+".mount_point", "mount point is required")
+			}
+			sv.checkFilesystemConfig(path, disk.FilesystemConfig)
+		case len(disk.Partitions) == 0:
+			v.addf(path+".partitions", "at least one partition or a filesystem is required")
+		}
+
+		if disk.Preserve && disk.FilesystemConfig.configured() {
+			v.addf(path+".preserve", "preserve keeps a partition table; use format: false to keep a filesystem on the whole disk")
+		}
+
+		switch disk.Table {
+		case "", TableGPT, TableMsdos:
+			if disk.Table != "" && disk.FilesystemConfig.configured() {
+				v.addf(path+".table", "a filesystem on the whole disk has no partition table")
+			}
+		default:
+			v.addf(path+".table", "unknown partition table %q (expected \"gpt\" or \"msdos\")", disk.Table)
+		}
+
+		biosBootable, holdsBoot := false, false
+		sv.disk = &shares{of: fmt.Sprintf("disk %q", disk.Device)}
+		sv.table = disk.Table
+		sv.diskBootable = ""
+		sv.preserveDisk = disk.Preserve
+		for idx, part := range disk.Partitions {
+			sv.logical = disk.LogicalPartition(idx)
+			sv.checkPartition(fmt.Sprintf("%s.partitions[%d]", path, idx), part)
+			biosBootable = biosBootable || part.Type == PartitionTypeBiosBoot || part.Bootable
+			holdsBoot = holdsBoot || (part.Type == PartitionTypeRaid && bootArrays[part.RaidArray])
+		}
+
+		// GRUB goes on every disk holding members of the arrays it boots
+		// from, so the system still boots when one of them fails.
+		if storage.Bootloader.Type == "bios" && holdsBoot && !biosBootable {
+			if disk.Table == TableMsdos {
+				v.addf(path+".partitions", "disk %q holds members of the RAID array GRUB boots from and needs a bootable partition for the bios bootloader", disk.Device)
+			} else {
+				v.addf(path+".partitions", "disk %q holds members of the RAID array GRUB boots from and needs a bios_boot partition for the bios bootloader", disk.Device)
+			}
+		}
+	}
+
+	arrayNames := make(map[string]bool)
+	for idx, array := range storage.Raid {
+		path := fmt.Sprintf("storage.raid[%d]", idx)
+
+		switch {
+		case array.Name == "":
+			v.addf(path+".name", "RAID array name is required")
+		case !raidNamePattern.MatchString(array.Name):
+			v.addf(path+".name", "invalid RAID array name %q (expected e.g. \"md0\")", array.Name)
+		case arrayNames[array.Name]:
+			v.addf(path+".name", "duplicate RAID array %q", array.Name)
+		}
+		arrayNames[array.Name] = true
+
+		// A kept array is assembled from its existing members
+		if array.Preserved() || sv.preservedVGs[array.VolumeGroup] {
+			for _, member := range sv.raidMembers[array.Name] {
+				if !sv.kept[member] {
+					v.addf(member, "RAID array %q is kept, so its members must be on a disk with preserve", array.Name)
+				}
+			}
+		}
+
+		members := len(sv.raidMembers[array.Name])
+		if minimum, ok := raidMinMembers[array.Level]; !ok {
+			v.addf(path+".level", "unsupported RAID level %q (expected 0, 1, 5, 6 or 10)", array.Level)
+		} else if members < minimum {
+			v.addf(path+".level", "RAID level %s needs at least %d raid partitions, but %d name array %q", array.Level, minimum, members, array.Name)
+		}
+
+		if array.Metadata != "" && !raidMetadataFormats[array.Metadata] {
+			v.addf(path+".metadata", "unsupported RAID metadata format %q", array.Metadata)
+		}
+
+		if array.Encryption != nil {
+			sv.checkEncryption(path+".encryption", array.Encryption, sv.bootHeld(array.FilesystemConfig, array.VolumeGroup))
+		}
+
+		switch {
+		case array.VolumeGroup != "":
+			if array.FilesystemConfig.configured() {
+				v.addf(path, "RAID array used as a physical volume must not have a filesystem or mount point")
+			}
+			if !lvmNamePattern.MatchString(array.VolumeGroup) {
+				v.addf(path+".volume_group", "invalid volume group name %q", array.VolumeGroup)
+			}
+			sv.pvs[array.VolumeGroup] = append(sv.pvs[array.VolumeGroup], path)
+		case array.Filesystem != "":
+			sv.checkFilesystemConfig(path, array.FilesystemConfig)
+		case array.MountPoint != "":
+			v.addf(path+".mount_point", "mount point %q requires a filesystem", array.MountPoint)
+		default:
+			v.addf(path, "RAID array needs a filesystem or a volume group")
+		}
+	}
+
+	var undefined []string
+	for name := range sv.raidMembers {
+		if !arrayNames[name] {
+			undefined = append(undefined, name)
+		}
+	}
+	sort.Strings(undefined)
+	for _, name := range undefined {
+		for _, member := range sv.raidMembers[name] {
+			v.addf(member+".raid_array", "RAID array %q is not defined in storage.raid", name)
+		}
+	}
+
+	vgNames := make(map[string]bool)
+	for idx, vg := range storage.VolumeGroups {
+		path := fmt.Sprintf("storage.volume_groups[%d]", idx)
+
+		switch {
+		case vg.Name == "":
+			v.addf(path+".name", "volume group name is required")
+		case !lvmNamePattern.MatchString(vg.Name):
+			v.addf(path+".name", "invalid volume group name %q", vg.Name)
+		case vgNames[vg.Name]:
+			v.addf(path+".name", "duplicate volume group %q", vg.Name)
+		case len(sv.pvs[vg.Name]) == 0:
+			v.addf(path+".name", "volume group %q has no lvm_pv partition or RAID array", vg.Name)
+		}
+		vgNames[vg.Name] = true
+
+		for lvIdx, lv := range vg.LogicalVolumes {
+			sv.checkLogicalVolume(fmt.Sprintf("%s.logical_volumes[%d]", path, lvIdx), vg.Name, lv)
+		}
+	}
+
+	// The root partition type is specific to the architecture
+	if sv.hasType[PartitionTypeLinuxRootX8664] && c.Installation.Architecture != "amd64" {
+		v.addf("installation.architecture", "linux_root_x86_64 partitions require architecture \"amd64\"")
+	}
+
+	if _, ok := sv.mountPoints["/"]; !ok {
+		v.addf("storage", "no filesystem is mounted at /")
+	}
+
+	for idx, tmpfs := range storage.Tmpfs {
+		path := fmt.Sprintf("storage.tmpfs[%d]", idx)
+
+		switch tmpfs.MountPoint {
+		case "":
+			v.addf(path+".mount_point", "mount point is required")
+		case "/":
+			v.addf(path+".mount_point", "tmpfs cannot be mounted at /")
+		default:
+			sv.checkMountPoint(path+".mount_point", tmpfs.MountPoint)
+		}
+
+		if tmpfs.Size != "" && !tmpfsPercentPattern.MatchString(tmpfs.Size) {
+			if _, err := ParseSize(tmpfs.Size); err != nil {
+				v.addf(path+".size", "%v", err)
+			}
+		}
+		sv.checkMountOptions(path+".mount_options", tmpfs.MountOptions)
+	}
+
+	if storage.Swap != nil {
+		sv.checkSwap("storage.swap", storage.Swap)
+	}
+
+	switch storage.Bootloader.Type {
+	case "efi":
+		if !sv.hasType[PartitionTypeEfiSystem] {
+			v.addf("storage.bootloader.type", "efi bootloader requires an efi_system partition")
+		} else if !sv.efiMounted {
+			v.addf("storage.bootloader.type", "efi bootloader requires the efi_system partition to be mounted at /boot/efi")
+		}
+	case "bios":
+		if !sv.hasType[PartitionTypeBiosBoot] && !sv.bootable {
+			v.addf("storage.bootloader.type", "bios bootloader requires a bios_boot partition, or a bootable partition on an msdos disk")
+		}
+	case "":
+		v.addf("storage.bootloader.type", "bootloader type is required")
+	default:
+		v.addf("storage.bootloader.type", "unknown bootloader type %q (expected \"bios\" or \"efi\")", storage.Bootloader.Type)
+	}
+}
+
+func (sv *storageValidator) checkDevice(path, device string) {
+	if device == "" {
+		sv.addf(path, "device is required")
+	} else if !strings.HasPrefix(device, "/dev/") {
+		sv.addf(path, "device %q must be a path under /dev", device)
+	}
+}
+
+func (sv *storageValidator) checkMountPoint(path, mountPoint string) {
+	if !filepath.IsAbs(mountPoint) || filepath.Clean(mountPoint) != mountPoint {
+		sv.addf(path, "mount point %q must be a clean absolute path", mountPoint)
+		return
+	}
+	if other, ok := sv.mountPoints[mountPoint]; ok {
+		sv.addf(path, "mount point %q is already used by %s", mountPoint, other)
+		return
+	}
+	sv.mountPoints[mountPoint] = parentPath(path)
+}
+
+func (sv *storageValidator) checkFilesystem(path, fsType string) {
+	if !supportedFilesystems[fsType] {
+		sv.addf(path, "unsupported filesystem %q", fsType)
+	}
+}
+
+func (sv *storageValidator) checkPartition(path string, part Partition) {
+	sv.hasType[part.Type] = true
+
+	if _, ok := partitionTypeGUIDs[part.Type]; !ok {
+		if part.Type == "" {
+			sv.addf(path+".type", "partition type is required")
+		} else {
+			sv.addf(path+".type", "unknown partition type %q", part.Type)
+		}
+	}
+
+	// Partitions of a kept partition table already have their size
+	if !sv.preserveDisk || part.Size != "" {
+		sv.checkSize(path, part.Size, part.SizeBounds, sv.disk)
+	}
+	sv.kept[path] = sv.preserveDisk
+	if !sv.preserveDisk {
+		if part.Preserved() {
+			sv.addf(path+".format", "format: false requires preserve on the disk")
+		}
+		if part.Type == PartitionTypeLvmPV && sv.preservedVGs[part.VolumeGroup] {
+			sv.addf(path+".volume_group", "volume group %q is kept, so its physical volumes must be on a disk with preserve", part.VolumeGroup)
+		}
+	}
+
+	if sv.table == TableMsdos {
+		sv.checkMsdosEntry(path, part)
+	} else {
+		sv.checkGPTEntry(path, part)
+		if part.Bootable {
+			sv.addf(path+".bootable", "bootable requires an msdos partition table; use a bios_boot partition on gpt")
+		}
+	}
+
+	switch part.Type {
+	case PartitionTypeBiosBoot:
+		if part.FilesystemConfig.configured() {
+			sv.addf(path, "bios_boot partition must not have a filesystem or mount point")
+		}
+	case PartitionTypeLvmPV:
+		if part.FilesystemConfig.configured() {
+			sv.addf(path, "lvm_pv partition must not have a filesystem or mount point")
+		}
+	case PartitionTypeRaid:
+		if part.FilesystemConfig.configured() {
+			sv.addf(path, "raid partition must not have a filesystem or mount point; set them on the RAID array")
+		}
+	case PartitionTypeMsr:
+		if part.FilesystemConfig.configured() {
+			sv.addf(path, "msr partition must not have a filesystem or mount point")
+		}
+	case PartitionTypeLinuxSwap:
+		if part.Filesystem != "" && part.Filesystem != "swap" {
+			sv.addf(path+".filesystem", "linux_swap partition must use swap, not %q", part.Filesystem)
+		}
+	case PartitionTypeLuks:
+		if part.Encryption == nil {
+			sv.addf(path+".encryption", "encryption is required for luks partitions")
+		}
+	}
+
+	if part.Encryption != nil {
+		switch part.Type {
+		case PartitionTypeBoot, PartitionTypeLinux, PartitionTypeLvmPV, PartitionTypeLuks,
+			PartitionTypeLinuxSwap, PartitionTypeLinuxHome, PartitionTypeLinuxRootX8664:
+			sv.checkEncryption(path+".encryption", part.Encryption, sv.bootHeld(part.FilesystemConfig, part.VolumeGroup))
+		case PartitionTypeRaid:
+			sv.addf(path+".encryption", "raid partitions cannot be encrypted; encrypt the RAID array instead")
+		default:
+			sv.addf(path+".encryption", "%s partitions cannot be encrypted", part.Type)
+		}
+	}
+
+	if part.Type == PartitionTypeRaid {
+		if part.RaidArray == "" {
+			sv.addf(path+".raid_array", "RAID array is required for raid partitions")
+		} else {
+			sv.raidMembers[part.RaidArray] = append(sv.raidMembers[part.RaidArray], path)
+		}
+	} else if part.RaidArray != "" {
+		sv.addf(path+".raid_array", "RAID array is only valid for raid partitions")
+	}
+
+	if part.Type == PartitionTypeEfiSystem {
+		if part.Filesystem != "" && part.Filesystem != "vfat" {
+			sv.addf(path+".filesystem", "efi_system partition must use vfat, not %q", part.Filesystem)
+		}
+		if part.MountPoint == "/boot/efi" {
+			sv.efiMounted = true
+		}
+	}
+
+	if part.Type != PartitionTypeLvmPV {
+		if part.VolumeGroup != "" {
+			sv.addf(path+".volume_group", "volume group is only valid for lvm_pv partitions")
+		}
+		if len(part.LogicalVolumes) > 0 {
+			sv.addf(path+".logical_volumes", "logical volumes are only valid for lvm_pv partitions")
+		}
+	}
+
+	switch part.Type {
+	case PartitionTypeBiosBoot, PartitionTypeLvmPV, PartitionTypeRaid, PartitionTypeMsr:
+	default:
+		if part.MountPoint != "" && part.Filesystem == "" {
+			sv.addf(path+".mount_point", "mount point %q requires a filesystem", part.MountPoint)
+		}
+		sv.checkFilesystemConfig(path, part.FilesystemConfig)
+	}
+
+	if part.Type != PartitionTypeLvmPV {
+		return
+	}
+
+	switch {
+	case part.VolumeGroup == "":
+		sv.addf(path+".volume_group", "volume group is required for lvm_pv partitions")
+		return
+	case !lvmNamePattern.MatchString(part.VolumeGroup):
+		sv.addf(path+".volume_group", "invalid volume group name %q", part.VolumeGroup)
+	}
+	sv.pvs[part.VolumeGroup] = append(sv.pvs[part.VolumeGroup], path)
+
+	for lvIdx, lv := range part.LogicalVolumes {
+		sv.checkLogicalVolume(fmt.Sprintf("%s.logical_volumes[%d]", path, lvIdx), part.VolumeGroup, lv)
+	}
+}
+
+// checkGPTEntry checks the GPT type GUID, name, unique GUID and attributes
+// of a partition.
+func (sv *storageValidator) checkGPTEntry(path string, part Partition) {
+	if part.TypeGUID != "" && !guidPattern.MatchString(part.TypeGUID) {
+		sv.addf(path+".type_guid", "invalid GUID %q", part.TypeGUID)
+	}
+
+	// GPT partition names hold 36 UTF-16 code units
+	if len(utf16.Encode([]rune(part.PartitionLabel))) > 36 {
+		sv.addf(path+".partition_label", "partition label %q is longer than 36 characters", part.PartitionLabel)
+	}
+
+	if uuid := part.PartitionUUID; uuid != "" {
+		switch {
+		case !guidPattern.MatchString(uuid):
+			sv.addf(path+".partition_uuid", "invalid GUID %q", uuid)
+		case sv.partitionUUIDs[strings.ToUpper(uuid)] != "":
+			sv.addf(path+".partition_uuid", "partition UUID %q is already used by %s", uuid, sv.partitionUUIDs[strings.ToUpper(uuid)])
+		default:
+			sv.partitionUUIDs[strings.ToUpper(uuid)] = path
+		}
+	}
+
+	for idx, attr := range part.Attributes {
+		if _, err := ParseGPTAttribute(attr); err != nil {
+			sv.addf(fmt.Sprintf("%s.attributes[%d]", path, idx), "%v", err)
+		}
+	}
+}
+
+// checkMsdosEntry checks that a partition on an msdos partition table has
+// an MBR type and no GPT settings, and that the boot flag is set on at most
+// one primary partition.
+func (sv *storageValidator) checkMsdosEntry(path string, part Partition) {
+	if _, ok := partitionTypeGUIDs[part.Type]; ok && part.MBRTypeID() == "" {
+		sv.addf(path+".type", "%s partitions require a gpt partition table", part.Type)
+	}
+	if part.TypeGUID != "" || part.PartitionLabel != "" || part.PartitionUUID != "" || len(part.Attributes) > 0 {
+		sv.addf(path, "type_guid, partition_label, partition_uuid and attributes require a gpt partition table")
+	}
+	if !part.Bootable {
+		return
+	}
+
+	// The MBR boot code jumps to the one active primary partition
+	switch {
+	case sv.logical:
+		sv.addf(path+".bootable", "logical partitions cannot be bootable; only the first %d partitions are primary", MsdosPrimaryLimit-1)
+	case sv.diskBootable != "":
+		sv.addf(path+".bootable", "only one partition per disk can be bootable, but %s already is", sv.diskBootable)
+	default:
+		sv.diskBootable = path
+	}
+	sv.bootable = true
+}
+
+// bootHeld returns the mount point GRUB needs to read that is held by a
+// device with filesystem fs or physical volume of vg, or "" if there is none.
+func (sv *storageValidator) bootHeld(fs FilesystemConfig, vg string) string {
+	switch {
+	case fs.mountedAt("/boot/efi"):
+		return "/boot/efi"
+	case fs.mountedAt(sv.boot):
+		return sv.boot
+	case vg != "" && sv.bootGroups[vg]:
+		return sv.boot
+	}
+	return ""
+}
+
+// checkEncryption checks the encryption of a device holding boot, the mount
+// point returned by bootHeld.
+func (sv *storageValidator) checkEncryption(path string, enc *Encryption, boot string) {
+	switch {
+	case enc.Name == "":
+		sv.addf(path+".name", "mapping name is required")
+	case !mappingNamePattern.MatchString(enc.Name):
+		sv.addf(path+".name", "invalid mapping name %q", enc.Name)
+	case sv.mappings[enc.Name] != "":
+		sv.addf(path+".name", "mapping %q is already used by %s", enc.Name, sv.mappings[enc.Name])
+	default:
+		sv.mappings[enc.Name] = path
+	}
+
+	switch {
+	case enc.Passphrase != "" && enc.Keyfile != "":
+		sv.addf(path, "only one of passphrase or keyfile may be set")
+	case enc.Passphrase == "" && enc.Keyfile == "":
+		sv.addf(path, "passphrase or keyfile is required")
+	case enc.Keyfile != "" && !filepath.IsAbs(enc.Keyfile):
+		sv.addf(path+".keyfile", "keyfile %q must be an absolute path", enc.Keyfile)
+	}
+
+	// GRUB reads the kernel and initramfs before anything is unlocked.
+	switch boot {
+	case "":
+	case "/":
+		sv.addf(path, "/ cannot be encrypted without a separate unencrypted /boot")
+	default:
+		sv.addf(path, "%s cannot be encrypted", boot)
+	}
+}
+
+func (sv *storageValidator) checkLogicalVolume(path, vg string, lv LogicalVolume) {
+	if sv.lvs[vg] == nil {
+		sv.lvs[vg] = make(map[string]string)
+	}
+
+	switch {
+	case lv.Name == "":
+		sv.addf(path+".name", "logical volume name is required")
+	case !validLVName(lv.Name):
+		sv.addf(path+".name", "invalid logical volume name %q", lv.Name)
+	case sv.lvs[vg][lv.Name] != "":
+		sv.addf(path+".name", "logical volume %q is already defined in volume group %q by %s", lv.Name, vg, sv.lvs[vg][lv.Name])
+	default:
+		sv.lvs[vg][lv.Name] = path
+	}
+
+	if sv.vgShares[vg] == nil {
+		sv.vgShares[vg] = &shares{of: fmt.Sprintf("volume group %q", vg)}
+	}
+	// Kept logical volumes already have their size
+	if !lv.Preserved() || lv.Size != "" {
+		sv.checkSize(path, lv.Size, lv.SizeBounds, sv.vgShares[vg])
+	}
+	if lv.Preserved() && !sv.preservedVGs[vg] {
+		sv.addf(path+".format", "format: false requires preserve on volume group %q in storage.volume_groups", vg)
+	}
+
+	if lv.Filesystem == "" {
+		sv.addf(path+".filesystem", "filesystem is required")
+	}
+	if lv.MountPoint == "" && len(lv.Subvolumes) == 0 && lv.Filesystem != "swap" {
+		sv.addf(path+".mount_point", "mount point is required")
+	}
+	sv.checkFilesystemConfig(path, lv.FilesystemConfig)
+}
+
+// checkSize checks a partition or logical volume size and its bounds, and
+// adds percentage sizes to total.
+func (sv *storageValidator) checkSize(path, spec string, bounds SizeBounds, total *shares) {
+	size, err := ParseSizeSpec(spec)
+	if err != nil {
+		sv.addf(path+".size", "%v", err)
+	}
+
+	var minimum, maximum uint64
+	if bounds.MinSize != "" {
+		if minimum, err = ParseSize(bounds.MinSize); err != nil {
+			sv.addf(path+".min_size", "%v", err)
+		}
+	}
+	if bounds.MaxSize != "" {
+		if maximum, err = ParseSize(bounds.MaxSize); err != nil {
+			sv.addf(path+".max_size", "%v", err)
+		}
+	}
+	if minimum > 0 && maximum > 0 && minimum > maximum {
+		sv.addf(path+".min_size", "min_size %q is larger than max_size %q", bounds.MinSize, bounds.MaxSize)
+	}
+
+	switch size.Kind {
+	case SizeAbsolute:
+		if bounds.Set() && size.Bytes > 0 {
+			sv.addf(path, "min_size and max_size only apply to percentage sizes")
+		}
+	case SizePercent:
+		total.percent += size.Percent
+		if total.percent > 100 && total.percent-size.Percent <= 100 {
+			sv.addf(path+".size", "percentage sizes of %s add up to more than 100%%", total.of)
+		}
+	case SizePercentFree:
+		total.free += size.Percent
+		if total.free > 100 && total.free-size.Percent <= 100 {
+			sv.addf(path+".size", "%%FREE sizes of %s add up to more than 100%%", total.of)
+		}
+	}
+}
+
+// checkFilesystemConfig checks the filesystem, mount point and subvolumes
+// of a partition, RAID array or logical volume. Whether a filesystem is
+// required at all is up to the caller.
+func (sv *storageValidator) checkFilesystemConfig(path string, fs FilesystemConfig) {
+	if fs.Filesystem != "" {
+		sv.checkFilesystem(path+".filesystem", fs.Filesystem)
+	}
+	if fs.MountPoint != "" {
+		sv.checkMountPoint(path+".mount_point", fs.MountPoint)
+	}
+
+	if fs.Filesystem == "" && (fs.Label != "" || len(fs.MkfsOptions) > 0 || len(fs.MountOptions) > 0) {
+		sv.addf(path, "label, mkfs_options and mount_options require a filesystem")
+	}
+	if fs.Filesystem == "swap" && fs.MountPoint != "" {
+		sv.addf(path+".mount_point", "swap is not mounted and cannot have a mount point")
+	}
+	if fs.Preserved() {
+		switch {
+		case fs.Filesystem == "":
+			sv.addf(path+".format", "format: false requires the filesystem of the existing volume")
+		case fs.MountPoint == "/":
+			sv.addf(path+".format", "the filesystem mounted at / is always formatted")
+		case fs.Label != "" || len(fs.MkfsOptions) > 0:
+			sv.addf(path+".format", "label and mkfs_options only apply when formatting")
+		}
+	}
+	if fs.Resume {
+		if fs.Filesystem != "swap" {
+			sv.addf(path+".resume", "resume requires filesystem \"swap\"")
+		} else if sv.resume != "" {
+			sv.addf(path+".resume", "resume is already set on %s", sv.resume)
+		} else {
+			sv.resume = path
+		}
+	}
+	if len(fs.MountOptions) > 0 && fs.MountPoint == "" && len(fs.Subvolumes) == 0 && fs.Filesystem != "swap" {
+		sv.addf(path+".mount_options", "mount options require a mount point or subvolumes")
+	}
+	sv.checkMountOptions(path+".mount_options", fs.MountOptions)
+	if limit, ok := labelLimits[fs.Filesystem]; ok && len(fs.Label) > limit {
+		sv.addf(path+".label", "label %q is longer than %d characters, the limit of %s", fs.Label, limit, fs.Filesystem)
+	}
+	if strings.ContainsAny(fs.Label, " \t\n") {
+		sv.addf(path+".label", "label %q must not contain whitespace", fs.Label)
+	}
+	for idx, option := range fs.MkfsOptions {
+		if option == "" {
+			sv.addf(fmt.Sprintf("%s.mkfs_options[%d]", path, idx), "mkfs option must not be empty")
+		}
+	}
+
+	if len(fs.Subvolumes) == 0 {
+		return
+	}
+	if fs.Filesystem != "btrfs" {
+		sv.addf(path+".subvolumes", "subvolumes require a btrfs filesystem")
+	}
+
+	names := make(map[string]bool)
+	for idx, sub := range fs.Subvolumes {
+		subPath := fmt.Sprintf("%s.subvolumes[%d]", path, idx)
+
+		switch {
+		case sub.Name == "":
+			sv.addf(subPath+".name", "subvolume name is required")
+		case !validSubvolumeName(sub.Name):
+			sv.addf(subPath+".name", "invalid subvolume name %q", sub.Name)
+		case names[sub.Name]:
+			sv.addf(subPath+".name", "duplicate subvolume %q", sub.Name)
+		}
+		names[sub.Name] = true
+
+		if sub.MountPoint != "" {
+			sv.checkMountPoint(subPath+".mount_point", sub.MountPoint)
+		} else if len(sub.MountOptions) > 0 {
+			sv.addf(subPath+".mount_options", "mount options require a mount point")
+		}
+		sv.checkMountOptions(subPath+".mount_options", sub.MountOptions)
+		if sub.Compression != "" && !compressionPattern.MatchString(sub.Compression) {
+			sv.addf(subPath+".compression", "unsupported compression %q (expected zlib, lzo or zstd, optionally followed by :level)", sub.Compression)
+		}
+	}
+}
+
+func (sv *storageValidator) checkSwap(path string, swap *Swap) {
+	if file := swap.File; file != nil {
+		switch {
+		case file.Path == "":
+			sv.addf(path+".file.path", "swap file path is required")
+		case !filepath.IsAbs(file.Path) || filepath.Clean(file.Path) != file.Path || file.Path == "/":
+			sv.addf(path+".file.path", "swap file path %q must be a clean absolute file path", file.Path)
+		}
+		if _, err := ParseSize(file.Size); err != nil {
+			sv.addf(path+".file.size", "%v", err)
+		}
+	}
+
+	if zram := swap.Zram; zram != nil && zram.Compression != "" && !zramCompression[zram.Compression] {
+		sv.addf(path+".zram.compression", "unsupported zram compression %q", zram.Compression)
+	}
+}
+
+// checkMountOptions rejects options that would break the comma separated
+// option list of mount(8) and fstab(5).
+func (sv *storageValidator) checkMountOptions(path string, options []string) {
+	for idx, option := range options {
+		if option == "" || strings.ContainsAny(option, ", \t\n") {
+			sv.addf(fmt.Sprintf("%s[%d]", path, idx), "invalid mount option %q", option)
+		}
+	}
+}
+
+// validSubvolumeName accepts clean paths relative to the top-level volume,
+// such as "@" or "@/var/log".
+func validSubvolumeName(name string) bool {
+	return !filepath.IsAbs(name) && filepath.Clean(name) == name &&
+		name != "." && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// validLVName applies the naming restrictions documented in lvm(8).
+func validLVName(name string) bool {
+	if !lvmNamePattern.MatchString(name) || name == "." || name == ".." || len(name) > 127 {
+		return false
+	}
+	if strings.HasPrefix(name, "snapshot") || strings.HasPrefix(name, "pvmove") {
+		return false
+	}
+	for _, reserved := range []string{"_cdata", "_cmeta", "_corig", "_mlog", "_mimage", "_pmspare", "_rimage", "_rmeta", "_tdata", "_tmeta", "_vorigin"} {
+		if strings.Contains(name, reserved) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Config) validateSystem(v *validator) {
+	hostname := c.System.Hostname
+	if hostname == "" {
+		v.addf("system.hostname", "hostname is required")
+	} else if !validHostname(hostname) {
+		v.addf("system.hostname", "invalid hostname %q", hostname)
+	}
+}
+
+func validHostname(hostname string) bool {
+	if len(hostname) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostnamePattern.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Config) validateNetwork(v *validator) {
+	network := &c.Network
+
+	if network.Interface == "" {
+		v.addf("network.interface", "interface is required")
+	}
+
+	switch network.Type {
+	case "dhcp":
+		if network.Address != "" || network.Netmask != "" || network.Gateway != "" {
+			v.addf("network", "address, netmask and gateway are only valid for static networks")
+		}
+	case "static":
+		checkIP := func(field, value string) {
+			if value == "" {
+				v.addf("network."+field, "%s is required for static networks", field)
+			} else if net.ParseIP(value) == nil {
+				v.addf("network."+field, "invalid IP address %q", value)
+			}
+		}
+		checkIP("address", network.Address)
+		checkIP("netmask", network.Netmask)
+		checkIP("gateway", network.Gateway)
+	case "":
+		v.addf("network.type", "network type is required")
+	default:
+		v.addf("network.type", "unsupported network type %q (expected \"dhcp\" or \"static\")", network.Type)
+	}
+}
+
+func (c *Config) validateGroups(v *validator) {
+	names := make(map[string]bool)
+	gids := make(map[int]bool)
+	for idx, group := range c.Groups {
+		path := fmt.Sprintf("groups[%d]", idx)
+
+		switch {
+		case group.Name == "":
+			v.addf(path+".name", "group name is required")
+		case !usernamePattern.MatchString(group.Name) || len(group.Name) > 32:
+			v.addf(path+".name", "invalid group name %q", group.Name)
+		case names[group.Name]:
+			v.addf(path+".name", "duplicate group name %q", group.Name)
+		}
+		names[group.Name] = true
+
+		if group.GID != nil {
+			switch {
+			case *group.GID <= 0:
+				v.addf(path+".gid", "gid must be positive")
+			case gids[*group.GID]:
+				v.addf(path+".gid", "duplicate gid %d", *group.GID)
+			}
+			gids[*group.GID] = true
+		}
+	}
+}
+
+func (c *Config) validateUsers(v *validator) {
+	usernames := make(map[string]bool)
+	uids := make(map[int]bool)
+	for idx, user := range c.Users {
+		path := fmt.Sprintf("users[%d]", idx)
+
+		switch {
+		case user.Username == "":
+			v.addf(path+".username", "username is required")
+		case !usernamePattern.MatchString(user.Username) || len(user.Username) > 32:
+			v.addf(path+".username", "invalid username %q", user.Username)
+		case usernames[user.Username]:
+			v.addf(path+".username", "duplicate username %q", user.Username)
+		}
+		usernames[user.Username] = true
+
+		validateCredentials(v, path, user.Credentials, true)
+		if len(user.SSHAuthorizedKeys) > 0 && user.CreateHome != nil && !*user.CreateHome {
+			v.addf(path+".ssh_authorized_keys", "authorized keys require a home directory; remove create_home: false")
+		}
+
+		if user.UID != nil {
+			switch {
+			case *user.UID <= 0:
+				v.addf(path+".uid", "uid must be positive")
+			case uids[*user.UID]:
+				v.addf(path+".uid", "duplicate uid %d", *user.UID)
+			}
+			uids[*user.UID] = true
+		}
+
+		if user.PrimaryGroup != "" && !usernamePattern.MatchString(user.PrimaryGroup) {
+			v.addf(path+".primary_group", "invalid group name %q", user.PrimaryGroup)
+		}
+
+		if user.Shell != "" && !filepath.IsAbs(user.Shell) {
+			v.addf(path+".shell", "shell %q must be an absolute path", user.Shell)
+		}
+
+		if user.Home != "" && (!filepath.IsAbs(user.Home) || filepath.Clean(user.Home) != user.Home) {
+			v.addf(path+".home", "home %q must be a clean absolute path", user.Home)
+		}
+
+		if strings.ContainsAny(user.Comment, ":\n") {
+			v.addf(path+".comment", "comment must not contain \":\" or newlines")
+		}
+
+		for groupIdx, group := range user.Groups {
+			if !usernamePattern.MatchString(group) {
+				v.addf(fmt.Sprintf("%s.groups[%d]", path, groupIdx), "invalid group name %q", group)
+			}
+		}
+	}
+}
+
+func validateCredentials(v *validator, path string, creds Credentials, passwordRequired bool) {
+	set := 0
+	for _, ok := range []bool{creds.Password != "", creds.PasswordHash != "", creds.LockPassword} {
+		if ok {
+			set++
+		}
+	}
+	switch {
+	case passwordRequired && set != 1:
+		v.addf(path, "exactly one of password, password_hash or lock_password must be set")
+	case set > 1:
+		v.addf(path, "only one of password, password_hash or lock_password may be set")
+	}
+
+	if creds.PasswordHash != "" && !passwordHashPattern.MatchString(creds.PasswordHash) {
+		// Do not echo the hash, it is treated as a secret.
+		v.addf(path+".password_hash", "password hash must be a crypt(3) string such as \"$y$...\" or \"$6$...\"")
+	}
+
+	for idx, key := range creds.SSHAuthorizedKeys {
+		keyPath := fmt.Sprintf("%s.ssh_authorized_keys[%d]", path, idx)
+		if strings.TrimSpace(key) == "" {
+			v.addf(keyPath, "authorized key is empty")
+		} else if strings.ContainsAny(key, "\r\n") {
+			v.addf(keyPath, "authorized key must be a single line")
+		}
+	}
+}
+
+func (c *Config) validateRoot(v *validator) {
+	if c.Root == nil {
+		return
+	}
+
+	validateCredentials(v, "root", c.Root.Credentials, false)
+
+	switch c.Root.PermitSSHLogin {
+	case "", "yes", "no", "prohibit-password", "forced-commands-only":
+	default:
+		v.addf("root.permit_ssh_login", "unsupported policy %q (expected \"yes\", \"no\", \"prohibit-password\" or \"forced-commands-only\")", c.Root.PermitSSHLogin)
+	}
+}
+
+func (c *Config) validateInstallation(v *validator) {
+	inst := &c.Installation
+
+	switch {
+	case inst.MountPoint == "":
+		v.addf("installation.mount_point", "mount point is required")
+	case !filepath.IsAbs(inst.MountPoint) || filepath.Clean(inst.MountPoint) == "/":
+		v.addf("installation.mount_point", "mount point %q must be an absolute path other than /", inst.MountPoint)
+	}
+
+	if inst.Architecture == "" {
+		v.addf("installation.architecture", "architecture is required")
+	}
+
+	if inst.DebianVersion == "" {
+		v.addf("installation.debian_version", "debian version is required")
+	}
+}
@@ -0,0 +1,628 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// commonConfig completes a storage section into a valid configuration.
+const commonConfig = `
+system:
+  hostname: "debian-server"
+network:
+  interface: "ens3"
+  type: "dhcp"
+users:
+  - username: "admin"
+    password: "changeme"
+installation:
+  mount_point: "/mnt/debian"
+  architecture: "amd64"
+  debian_version: "bookworm"
+log_file: "/tmp/debian_install.log"
+`
+
+// loadYAML loads content as a configuration file.
+func loadYAML(t *testing.T, content string, opts LoadOptions) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path, opts)
+}
+
+// wantErrors checks that err lists exactly the errors in want, each written
+// as "LINE PATH: MESSAGE", where MESSAGE may be a prefix of the message.
+func wantErrors(t *testing.T, err error, want []string) {
+	t.Helper()
+	if len(want) == 0 {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want ValidationErrors", err)
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d:\n%v", len(errs), len(want), err)
+	}
+	for idx, e := range errs {
+		got := fmt.Sprintf("%d %s: %s", e.Line, e.Path, e.Message)
+		if !strings.HasPrefix(got, want[idx]) {
+			t.Errorf("error %d = %q, want %q", idx, got, want[idx])
+		}
+	}
+}
+
+func TestLoadConfigExamples(t *testing.T) {
+	for _, name := range []string{"config.yaml.bios", "config.yaml.efi"} {
+		if _, err := LoadConfig(filepath.Join("../../example", name), LoadOptions{}); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    []string
+	}{
+		{
+			name: "valid",
+			storage: `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "bios"
+  partitions:
+    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+		},
+		{
+			name: "bootable logical partition",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      table: "msdos"
+      partitions:
+        - {type: "linux", size: "1G"}
+        - {type: "linux", size: "1G"}
+        - {type: "linux", size: "1G"}
+        - {type: "linux", size: "1G", bootable: true}
+        - {type: "linux", size: "remaining", filesystem: "ext4", mount_point: "/"}
+`,
+			want: []string{"11 storage.disks[0].partitions[3].bootable: logical partitions cannot be bootable"},
+		},
+		{
+			name: "two bootable primary partitions",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      table: "msdos"
+      partitions:
+        - {type: "linux", size: "1G", bootable: true, filesystem: "ext4", mount_point: "/boot"}
+        - {type: "linux", size: "remaining", bootable: true, filesystem: "ext4", mount_point: "/"}
+`,
+			want: []string{"9 storage.disks[0].partitions[1].bootable: only one partition per disk can be bootable, but storage.disks[0].partitions[0] already is"},
+		},
+		{
+			name: "size without unit",
+			storage: `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "bios"
+  partitions:
+    - type: "bios_boot"
+      size: "2048"
+    - type: "boot"
+      size: "remaining"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+			want: []string{`8 storage.partitions[0].size: invalid size "2048": a unit is required`},
+		},
+		{
+			name: "unknown type and duplicate mount point",
+			storage: `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "bios"
+  partitions:
+    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+    - type: "bogus"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+			want: []string{
+				`13 storage.partitions[2].type: unknown partition type "bogus"`,
+				`16 storage.partitions[2].mount_point: mount point "/" is already used by storage.partitions[1]`,
+			},
+		},
+		{
+			name: "missing devices are reported at the enclosing section",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  partitions:
+    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+			want: []string{`1 storage.devices: at least one device is required`},
+		},
+		{
+			name: "efi without efi_system partition",
+			storage: `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "efi"
+  partitions:
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+			want: []string{`5 storage.bootloader.type: efi bootloader requires an efi_system partition`},
+		},
+		{
+			name: "legacy form with several devices",
+			storage: `storage:
+  devices:
+    - /dev/sda
+    - /dev/sdb
+  bootloader:
+    type: "bios"
+  partitions:
+    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+		},
+		{
+			name: "missing disk device is reported at the enclosing item",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "boot"
+          size: "10G"
+          filesystem: "ext4"
+          mount_point: "/"
+`,
+			want: []string{`5 storage.disks[0].device: device is required`},
+		},
+		{
+			name: "disks combined with the legacy form",
+			storage: `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sdb
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "boot"
+          size: "10G"
+          filesystem: "ext4"
+          mount_point: "/"
+`,
+			want: []string{`6 storage.disks: storage.disks cannot be combined with storage.devices and storage.partitions`},
+		},
+		{
+			name: "bios_boot only needed on disks of the boot array",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "raid"
+          size: "20G"
+          raid_array: "md0"
+    - device: /dev/sdb
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "raid"
+          size: "20G"
+          raid_array: "md0"
+    - device: /dev/sdc
+      partitions:
+        - type: "raid"
+          size: "100G"
+          raid_array: "md1"
+    - device: /dev/sdd
+      partitions:
+        - type: "raid"
+          size: "100G"
+          raid_array: "md1"
+  raid:
+    - name: "md0"
+      level: 1
+      volume_group: "vg0"
+    - name: "md1"
+      level: 1
+      filesystem: "ext4"
+      mount_point: "/srv"
+  volume_groups:
+    - name: "vg0"
+      logical_volumes:
+        - name: "root"
+          size: "10G"
+          filesystem: "ext4"
+          mount_point: "/"
+`,
+		},
+		{
+			name: "boot array disk without bios_boot",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "raid"
+          size: "20G"
+          raid_array: "md0"
+    - device: /dev/sdb
+      partitions:
+        - type: "raid"
+          size: "20G"
+          raid_array: "md0"
+  raid:
+    - name: "md0"
+      level: 1
+      filesystem: "ext4"
+      mount_point: "/"
+`,
+			want: []string{`13 storage.disks[1].partitions: disk "/dev/sdb" holds members of the RAID array GRUB boots from`},
+		},
+		{
+			name: "encrypted root physical volume with a plain /boot",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "boot"
+          size: "1G"
+          filesystem: "ext4"
+          mount_point: "/boot"
+        - type: "lvm_pv"
+          size: "20G"
+          volume_group: "vg0"
+          encryption:
+            name: "cryptroot"
+            passphrase: "secret"
+  volume_groups:
+    - name: "vg0"
+      logical_volumes:
+        - name: "root"
+          size: "10G"
+          filesystem: "ext4"
+          mount_point: "/"
+`,
+		},
+		{
+			name: "encrypted root without /boot",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "boot"
+          size: "10G"
+          filesystem: "ext4"
+          mount_point: "/"
+          encryption:
+            name: "cryptroot"
+            passphrase: "secret"
+`,
+			want: []string{"13 storage.disks[0].partitions[1].encryption: / cannot be encrypted without a separate unencrypted /boot"},
+		},
+		{
+			name: "/boot logical volume on an encrypted physical volume",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "lvm_pv"
+          size: "20G"
+          volume_group: "vg0"
+          encryption:
+            name: "cryptvg"
+            passphrase: "secret"
+  volume_groups:
+    - name: "vg0"
+      logical_volumes:
+        - name: "boot"
+          size: "1G"
+          filesystem: "ext4"
+          mount_point: "/boot"
+        - name: "root"
+          size: "10G"
+          filesystem: "ext4"
+          mount_point: "/"
+`,
+			want: []string{"12 storage.disks[0].partitions[1].encryption: /boot cannot be encrypted"},
+		},
+		{
+			name: "/boot logical volume on an encrypted RAID array",
+			storage: `storage:
+  bootloader:
+    type: "bios"
+  disks:
+    - device: /dev/sda
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "raid"
+          size: "20G"
+          raid_array: "md0"
+    - device: /dev/sdb
+      partitions:
+        - type: "bios_boot"
+          size: "2M"
+        - type: "raid"
+          size: "20G"
+          raid_array: "md0"
+  raid:
+    - name: "md0"
+      level: 1
+      volume_group: "vg0"
+      encryption:
+        name: "cryptmd0"
+        passphrase: "secret"
+  volume_groups:
+    - name: "vg0"
+      logical_volumes:
+        - name: "boot"
+          size: "1G"
+          filesystem: "ext4"
+          mount_point: "/boot"
+        - name: "root"
+          size: "10G"
+          filesystem: "ext4"
+          mount_point: "/"
+`,
+			want: []string{"23 storage.raid[0].encryption: /boot cannot be encrypted"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadYAML(t, tt.storage+commonConfig, LoadOptions{})
+			wantErrors(t, err, tt.want)
+		})
+	}
+}
+
+func TestValidateSystemErrors(t *testing.T) {
+	cfg := Config{}
+	err := cfg.Validate()
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got error %v, want ValidationErrors", err)
+	}
+	paths := make(map[string]bool)
+	for _, e := range errs {
+		if e.Line != 0 {
+			t.Errorf("%s: line %d without a file", e.Path, e.Line)
+		}
+		paths[e.Path] = true
+	}
+	for _, path := range []string{"system.hostname", "network.interface", "log_file", "storage.bootloader.type"} {
+		if !paths[path] {
+			t.Errorf("no error for %s in %v", path, err)
+		}
+	}
+}
+
+func TestValidateUsers(t *testing.T) {
+	const storage = `storage:
+  devices:
+    - /dev/sda
+  bootloader:
+    type: "bios"
+  partitions:
+    - type: "bios_boot"
+      size: "2M"
+    - type: "boot"
+      size: "10G"
+      filesystem: "ext4"
+      mount_point: "/"
+system:
+  hostname: "debian-server"
+network:
+  interface: "ens3"
+  type: "dhcp"
+installation:
+  mount_point: "/mnt/debian"
+  architecture: "amd64"
+  debian_version: "bookworm"
+log_file: "/tmp/debian_install.log"
+`
+
+	tests := []struct {
+		name  string
+		users string
+		want  []string
+	}{
+		{
+			name: "group named like the user",
+			users: `groups:
+  - name: "alice"
+    gid: 1000
+users:
+  - username: "alice"
+    uid: 1000
+    password: "changeme"
+`,
+		},
+		{
+			name: "authorized keys without home",
+			users: `users:
+  - username: "deploy"
+    lock_password: true
+    create_home: false
+    ssh_authorized_keys:
+      - "ssh-ed25519 AAAAC3Nza deploy@laptop"
+`,
+			want: []string{`27 users[0].ssh_authorized_keys: authorized keys require a home directory`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadYAML(t, storage+tt.users, LoadOptions{})
+			wantErrors(t, err, tt.want)
+		})
+	}
+}
+
+func TestDiskLayoutsLegacy(t *testing.T) {
+	storage := Storage{
+		Devices: []string{"/dev/sda", "/dev/sdb"},
+		Partitions: []Partition{
+			{Type: PartitionTypeBiosBoot, Size: "2M"},
+			{Type: PartitionTypeBoot, Size: "10G", FilesystemConfig: FilesystemConfig{Filesystem: "ext4", MountPoint: "/"}},
+		},
+	}
+
+	disks := storage.DiskLayouts()
+	if len(disks) != 2 {
+		t.Fatalf("DiskLayouts() returned %d disks, want 2", len(disks))
+	}
+	if got := disks[0].Partitions[1]; got.MountPoint != "/" {
+		t.Errorf("first disk lost its filesystem: %+v", got)
+	}
+	if got, want := disks[1].Partitions[1], (Partition{Type: PartitionTypeBoot, Size: "10G"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("second disk partition = %+v, want %+v", got, want)
+	}
+	if len(storage.Deprecations()) != 1 {
+		t.Errorf("Deprecations() = %v, want one entry", storage.Deprecations())
+	}
+}
+
+func TestBootRaidArrays(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    []string
+	}{
+		{
+			name: "separate /boot array",
+			storage: `raid:
+  - {name: md0, filesystem: ext4, mount_point: /boot}
+  - {name: md1, volume_group: vg0}
+volume_groups:
+  - name: vg0
+    logical_volumes:
+      - {name: root, filesystem: ext4, mount_point: /}
+`,
+			want: []string{"md0"},
+		},
+		{
+			name: "root on a volume group over an array",
+			storage: `raid:
+  - {name: md0, volume_group: vg0}
+  - {name: md1, filesystem: ext4, mount_point: /srv}
+volume_groups:
+  - name: vg0
+    logical_volumes:
+      - {name: root, filesystem: ext4, mount_point: /}
+`,
+			want: []string{"md0"},
+		},
+		{
+			name: "root subvolume on an array",
+			storage: `raid:
+  - name: md0
+    filesystem: btrfs
+    subvolumes:
+      - {name: "@", mount_point: /}
+`,
+			want: []string{"md0"},
+		},
+		{
+			name: "/boot on a plain partition",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: boot, filesystem: ext4, mount_point: /boot}
+raid:
+  - {name: md0, filesystem: ext4, mount_point: /}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var storage Storage
+			if err := yaml.Unmarshal([]byte(tt.storage), &storage); err != nil {
+				t.Fatal(err)
+			}
+
+			got := storage.BootRaidArrays()
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("BootRaidArrays() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
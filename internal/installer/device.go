@@ -0,0 +1,68 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// partitionPath returns the device node of partition number n on disk.
+//
+//	/dev/sda                      -> /dev/sda1
+//	/dev/nvme0n1, /dev/mmcblk0    -> /dev/nvme0n1p1, /dev/mmcblk0p1
+//	/dev/disk/by-id/ata-XYZ       -> /dev/disk/by-id/ata-XYZ-part1
+//
+// Kernel names ending in a digit take a "p" separator, and the udev
+// symlinks under /dev/disk/by-* get a "-part" suffix.
+func partitionPath(disk string, n int) string {
+	if strings.HasPrefix(disk, "/dev/disk/by-") {
+		return fmt.Sprintf("%s-part%d", disk, n)
+	}
+
+	base := filepath.Base(disk)
+	if last := base[len(base)-1]; last >= '0' && last <= '9' {
+		return fmt.Sprintf("%sp%d", disk, n)
+	}
+	return fmt.Sprintf("%s%d", disk, n)
+}
+
+// logicalVolumePath returns the device node of a logical volume.
+func logicalVolumePath(vg, lv string) string {
+	return fmt.Sprintf("/dev/%s/%s", vg, lv)
+}
+
+// raidArrayPath returns the device node of an md RAID array.
+func raidArrayPath(name string) string {
+	return "/dev/" + name
+}
+
+// mappingPath returns the device node of an unlocked LUKS container.
+func mappingPath(name string) string {
+	return "/dev/mapper/" + name
+}
+
+// deviceUUID returns the UUID of the filesystem, swap space or LUKS
+// container on device, as used in fstab and crypttab.
+func (i *Installer) deviceUUID(ctx context.Context, device string) (string, error) {
+	output, err := i.Runner.RunWithOutput(ctx, "blkid", "-s", "UUID", "-o", "value", device)
+	if err != nil {
+		return "", fmt.Errorf("failed to read UUID of %s: %v", device, err)
+	}
+
+	uuid := strings.TrimSpace(string(output))
+	if uuid == "" {
+		return "", fmt.Errorf("%s has no UUID", device)
+	}
+	return uuid, nil
+}
+
+// settleDevices waits until udev has processed all pending events, so
+// device nodes and /dev/disk symlinks of new partitions and logical volumes
+// exist before they are used.
+func (i *Installer) settleDevices(ctx context.Context) error {
+	if err := i.Runner.Run(ctx, "udevadm", "settle"); err != nil {
+		return fmt.Errorf("failed to wait for device nodes: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package installer
+
+import "testing"
+
+func TestPartitionPath(t *testing.T) {
+	tests := []struct {
+		disk string
+		n    int
+		want string
+	}{
+		{disk: "/dev/sda", n: 1, want: "/dev/sda1"},
+		{disk: "/dev/vdb", n: 12, want: "/dev/vdb12"},
+		{disk: "/dev/nvme0n1", n: 2, want: "/dev/nvme0n1p2"},
+		{disk: "/dev/mmcblk0", n: 1, want: "/dev/mmcblk0p1"},
+		{disk: "/dev/loop0", n: 3, want: "/dev/loop0p3"},
+		{disk: "/dev/disk/by-id/ata-WDC_WD10EZEX-00BN5A0_WD-WCC3F1234567", n: 1,
+			want: "/dev/disk/by-id/ata-WDC_WD10EZEX-00BN5A0_WD-WCC3F1234567-part1"},
+		{disk: "/dev/disk/by-id/nvme-eui.0025388b91b2c4d1", n: 2,
+			want: "/dev/disk/by-id/nvme-eui.0025388b91b2c4d1-part2"},
+		{disk: "/dev/disk/by-path/pci-0000:00:1f.2-ata-1", n: 5,
+			want: "/dev/disk/by-path/pci-0000:00:1f.2-ata-1-part5"},
+	}
+
+	for _, tt := range tests {
+		if got := partitionPath(tt.disk, tt.n); got != tt.want {
+			t.Errorf("partitionPath(%q, %d) = %q, want %q", tt.disk, tt.n, got, tt.want)
+		}
+	}
+}
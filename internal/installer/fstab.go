@@ -0,0 +1,160 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+// fstabEntry is a line of /etc/fstab.
+type fstabEntry struct {
+	// device is the block device the entry was generated from, written as
+	// a comment above it.
+	device     string
+	source     string
+	mountPoint string
+	fsType     string
+	options    []string
+	pass       int
+}
+
+// generateFstab builds /etc/fstab from the storage configuration, referring
+// to filesystems and swap by UUID.
+func (i *Installer) generateFstab(ctx context.Context) error {
+	i.Logger.Info("Generating fstab")
+
+	entries, err := i.fstabEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	fstabPath := filepath.Join(i.Config.Installation.MountPoint, "etc/fstab")
+	if err := i.Runner.WriteFile(ctx, fstabPath, formatFstab(entries), 0644); err != nil {
+		return fmt.Errorf("failed to write fstab: %v", err)
+	}
+
+	return nil
+}
+
+func (i *Installer) fstabEntries(ctx context.Context) ([]fstabEntry, error) {
+	l := i.layout()
+
+	// Subvolumes of one btrfs filesystem share its UUID
+	uuids := make(map[string]string)
+	source := func(device string) (string, error) {
+		if _, ok := uuids[device]; !ok {
+			uuid, err := i.deviceUUID(ctx, device)
+			if err != nil {
+				return "", err
+			}
+			uuids[device] = uuid
+		}
+		return "UUID=" + uuids[device], nil
+	}
+
+	var entries []fstabEntry
+	for _, m := range l.mounts() {
+		src, err := source(m.device)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fstabEntry{
+			device:     m.device,
+			source:     src,
+			mountPoint: m.mountPoint,
+			fsType:     m.fsType,
+			options:    m.options,
+			pass:       fsckPass(m),
+		})
+	}
+
+	for _, fs := range l.filesystems {
+		if fs.Filesystem != "swap" {
+			continue
+		}
+		src, err := source(fs.device)
+		if err != nil {
+			return nil, err
+		}
+		options := fs.MountOptions
+		if len(options) == 0 {
+			options = []string{"sw"}
+		}
+		entries = append(entries, fstabEntry{
+			device:     fs.device,
+			source:     src,
+			mountPoint: "none",
+			fsType:     "swap",
+			options:    options,
+		})
+	}
+
+	if swap := i.Config.Storage.Swap; swap != nil && swap.File != nil {
+		entries = append(entries, fstabEntry{
+			source:     swap.File.Path,
+			mountPoint: "none",
+			fsType:     "swap",
+			options:    []string{"sw"},
+		})
+	}
+
+	for _, tmpfs := range i.Config.Storage.Tmpfs {
+		var options []string
+		if tmpfs.Size != "" {
+			size := tmpfs.Size
+			if bytes, err := config.ParseSize(size); err == nil {
+				size = config.FormatSize(bytes)
+			}
+			options = append(options, "size="+size)
+		}
+		options = append(options, tmpfs.MountOptions...)
+		entries = append(entries, fstabEntry{
+			source:     "tmpfs",
+			mountPoint: tmpfs.MountPoint,
+			fsType:     "tmpfs",
+			options:    options,
+		})
+	}
+
+	return entries, nil
+}
+
+// fsckPass returns the fstab pass number: the root filesystem is checked
+// first and the others after it. btrfs and xfs are never checked at boot,
+// their fsck is a no-op.
+func fsckPass(m mount) int {
+	switch {
+	case m.fsType == "btrfs" || m.fsType == "xfs":
+		return 0
+	case m.mountPoint == "/":
+		return 1
+	default:
+		return 2
+	}
+}
+
+func formatFstab(entries []fstabEntry) []byte {
+	var b strings.Builder
+	b.WriteString("# /etc/fstab: static file system information.\n")
+	b.WriteString("# Generated by debinstaller-go\n")
+	b.WriteString("#\n")
+	b.WriteString("# <file system>\t<mount point>\t<type>\t<options>\t<dump>\t<pass>\n")
+
+	for _, entry := range entries {
+		options := "defaults"
+		if len(entry.options) > 0 {
+			options = strings.Join(entry.options, ",")
+		}
+
+		b.WriteString("\n")
+		if entry.device != "" {
+			fmt.Fprintf(&b, "# %s\n", entry.device)
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t0\t%d\n", entry.source, entry.mountPoint, entry.fsType, options, entry.pass)
+	}
+
+	return []byte(b.String())
+}
@@ -0,0 +1,86 @@
+package installer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGenerateFstab(t *testing.T) {
+	i, runner := newTestInstaller(t, `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: efi_system, size: 512M, filesystem: vfat, mount_point: /boot/efi}
+      - {type: boot, size: 2G, filesystem: swap}
+      - {type: boot, size: 20G, filesystem: ext4, mount_point: /, mount_options: [noatime]}
+      - type: boot
+        size: 100G
+        filesystem: btrfs
+        subvolumes:
+          - {name: "@home", mount_point: /home, compression: zstd}
+          - {name: "@srv", mount_point: /srv}
+tmpfs:
+  - {mount_point: /tmp, size: 2G, mount_options: [nosuid, nodev]}
+swap:
+  file: {path: /swapfile, size: 1G}
+`)
+	runner.Respond("blkid -s UUID -o value /dev/sda1", "ABCD-1234\n", nil)
+	runner.Respond("blkid -s UUID -o value /dev/sda2", "uuid-swap\n", nil)
+	runner.Respond("blkid -s UUID -o value /dev/sda3", "uuid-root\n", nil)
+	runner.Respond("blkid -s UUID -o value /dev/sda4", "uuid-data\n", nil)
+
+	if err := i.generateFstab(context.Background()); err != nil {
+		t.Fatalf("generateFstab() failed: %v", err)
+	}
+
+	// Mount points are ordered by length, so parents come first
+	want := `# /etc/fstab: static file system information.
+# Generated by debinstaller-go
+#
+# <file system>	<mount point>	<type>	<options>	<dump>	<pass>
+
+# /dev/sda3
+UUID=uuid-root	/	ext4	noatime	0	1
+
+# /dev/sda4
+UUID=uuid-data	/srv	btrfs	subvol=@srv	0	0
+
+# /dev/sda4
+UUID=uuid-data	/home	btrfs	subvol=@home,compress=zstd	0	0
+
+# /dev/sda1
+UUID=ABCD-1234	/boot/efi	vfat	defaults	0	2
+
+# /dev/sda2
+UUID=uuid-swap	none	swap	sw	0	0
+
+/swapfile	none	swap	sw	0	0
+
+tmpfs	/tmp	tmpfs	size=2G,nosuid,nodev	0	0
+`
+	if got := string(runner.Files["/mnt/debian/etc/fstab"]); got != want {
+		t.Errorf("fstab =\n%s\nwant\n%s", got, want)
+	}
+
+	// The btrfs filesystem is looked up once for both subvolumes
+	count := 0
+	for _, line := range commandLines(runner) {
+		if line == "blkid -s UUID -o value /dev/sda4" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("blkid of /dev/sda4 ran %d times, want 1", count)
+	}
+}
+
+func TestGenerateFstabWithoutUUID(t *testing.T) {
+	i, _ := newTestInstaller(t, `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: boot, size: 20G, filesystem: ext4, mount_point: /}
+`)
+
+	if err := i.generateFstab(context.Background()); err == nil {
+		t.Error("generateFstab() succeeded for a device without UUID")
+	}
+}
@@ -1,9 +1,11 @@
 package installer
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/zinrai/debinstaller-go/internal/config"
 	"github.com/zinrai/debinstaller-go/internal/utils"
@@ -12,35 +14,176 @@ import (
 type Installer struct {
 	Config *config.Config
 	Logger *utils.Logger
+	Runner utils.Runner
+
+	// Resume continues from the first phase not recorded as completed in
+	// the state file, instead of starting over.
+	Resume bool
+
+	// Only and Skip restrict the run to a subset of phases, e.g. to rerun
+	// the bootloader phase against an already mounted target.
+	Only []string
+	Skip []string
+
+	planning       bool
+	specialMounted bool
+
+	mu      sync.Mutex
+	cleanup cleanup
 }
 
-func NewInstaller(cfg *config.Config, logger *utils.Logger) *Installer {
+func NewInstaller(cfg *config.Config, logger *utils.Logger, runner utils.Runner) *Installer {
 	return &Installer{
 		Config: cfg,
 		Logger: logger,
+		Runner: runner,
 	}
 }
 
-func (i *Installer) Install() error {
+func (i *Installer) Install(ctx context.Context) error {
 	i.Logger.Info("Starting Debian installation")
 
-	if err := i.prepareStorage(); err != nil {
-		return fmt.Errorf("failed to prepare storage: %v", err)
+	if err := i.install(ctx); err != nil {
+		return err
 	}
 
-	if err := i.installBaseSystem(); err != nil {
-		return fmt.Errorf("failed to install base system: %v", err)
+	i.Logger.Info("Debian installation completed successfully")
+	return nil
+}
+
+// Plan walks the whole installation with a RecordingRunner instead of the
+// configured Runner and returns the commands that would be run and the files
+// that would be written.
+func (i *Installer) Plan(ctx context.Context) (*utils.RecordingRunner, error) {
+	i.Logger.Info("Planning Debian installation")
+
+	recorder := utils.NewRecordingRunner()
+	runner := i.Runner
+	i.Runner = recorder
+	i.planning = true
+	defer func() {
+		i.Runner = runner
+		i.planning = false
+	}()
+
+	if err := i.install(ctx); err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// install runs every phase, recording each completed phase in the state
+// file, and always tears down the target afterwards, so a failed or
+// cancelled run does not leave mounts or active volume groups behind. The
+// exception is a successful partial run ending with the mount phase, which
+// is for preparing the target for later runs.
+func (i *Installer) install(ctx context.Context) (err error) {
+	keepTarget := false
+	defer func() {
+		if keepTarget && err == nil {
+			i.Logger.Info("Leaving target mounted at %s", i.Config.Installation.MountPoint)
+			return
+		}
+		// Teardown must run to completion even when ctx was cancelled.
+		if teardownErr := i.Teardown(context.WithoutCancel(ctx)); teardownErr != nil && err == nil {
+			err = fmt.Errorf("failed to tear down: %v", teardownErr)
+		}
+	}()
+
+	state, err := i.loadState()
+	if err != nil {
+		return err
+	}
+
+	// Without the mount phase in this run, the target is expected to be
+	// set up already and phases only verify what they depend on.
+	selected := i.selectedPhases()
+	keepTarget = i.partial() && len(selected) > 0 && selected[len(selected)-1].name == "mount"
+	providers := map[requirement]string{
+		requiresPartitions: "partition",
+		requiresArrays:     "raid",
+		requiresMappings:   "luks",
+		requiresVolumes:    "lvm",
+		requiresTarget:     "mount",
+		requiresChroot:     "mount",
+	}
+	running := make(map[string]bool)
+	checked := make(map[requirement]bool)
+	for _, p := range selected {
+		running[p.name] = true
+	}
+
+	i.specialMounted = false
+	for _, p := range selected {
+		if state.done(p.name) && !p.always {
+			i.Logger.Info("Skipping completed phase: %s", p.name)
+			if p.restore != nil {
+				if err := p.restore(ctx); err != nil {
+					return stepFailed(ctx, p.name, err)
+				}
+			}
+			continue
+		}
+
+		if provider, ok := providers[p.requires]; ok && !running[provider] && !checked[p.requires] {
+			if err := i.checkRequirement(ctx, p); err != nil {
+				return err
+			}
+			checked[p.requires] = true
+		}
+
+		if p.requires == requiresChroot && running["mount"] && !i.specialMounted {
+			if err := i.mountSpecialFilesystems(ctx); err != nil {
+				return stepFailed(ctx, p.name, fmt.Errorf("failed to mount special filesystems: %v", err))
+			}
+			i.specialMounted = true
+		}
+
+		if err := p.run(ctx); err != nil {
+			return stepFailed(ctx, p.name, err)
+		}
+
+		if !state.done(p.name) {
+			state.Completed = append(state.Completed, p.name)
+		}
+		if err := i.saveState(state); err != nil {
+			return err
+		}
 	}
 
-	if err := i.configureSystem(); err != nil {
-		return fmt.Errorf("failed to configure system: %v", err)
+	// Later runs without the mount phase expect the chroot to be ready
+	if keepTarget && !i.specialMounted {
+		if err := i.mountSpecialFilesystems(ctx); err != nil {
+			return fmt.Errorf("failed to mount special filesystems: %v", err)
+		}
+		i.specialMounted = true
 	}
 
-	i.Logger.Info("Debian installation completed successfully")
-	return nil
+	return i.removeState()
+}
+
+// stepFailed wraps the error of a failed phase. When the phase failed
+// because ctx was cancelled, the error records that the installation was
+// interrupted and where.
+func stepFailed(ctx context.Context, phase string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted during phase %s: %v", phase, err)
+	}
+	return fmt.Errorf("phase %s failed: %v", phase, err)
+}
+
+// succeeds runs a command that probes the host or target, e.g. getent, and
+// reports whether it succeeded. A plan cannot probe, so it shows what an
+// installation from scratch does.
+func (i *Installer) succeeds(ctx context.Context, name string, args ...string) bool {
+	if i.planning {
+		return false
+	}
+	return i.Runner.Run(ctx, name, args...) == nil
 }
 
-func (i *Installer) installBaseSystem() error {
+func (i *Installer) installBaseSystem(ctx context.Context) error {
 	i.Logger.Info("Installing base system")
 
 	grubPackage := "grub2"
@@ -56,8 +199,20 @@ func (i *Installer) installBaseSystem() error {
 		grubPackage,
 		"linux-image-" + i.Config.Installation.Architecture,
 	}
+	if len(i.Config.Storage.Raid) > 0 {
+		packages = append(packages, "mdadm")
+	}
+	if i.layout().usesFilesystem("btrfs") {
+		packages = append(packages, "btrfs-progs")
+	}
+	if swap := i.Config.Storage.Swap; swap != nil && swap.Zram != nil {
+		packages = append(packages, "systemd-zram-generator")
+	}
+	if len(i.Config.Storage.Encryptions()) > 0 {
+		packages = append(packages, "cryptsetup", "cryptsetup-initramfs")
+	}
 
-	if err := utils.RunCommand(i.Logger, "debootstrap",
+	if err := i.Runner.Run(ctx, "debootstrap",
 		"--arch="+i.Config.Installation.Architecture,
 		"--include="+strings.Join(packages, ","),
 		i.Config.Installation.DebianVersion,
@@ -69,7 +224,7 @@ func (i *Installer) installBaseSystem() error {
 	return nil
 }
 
-func (i *Installer) mountSpecialFilesystems() error {
+func (i *Installer) mountSpecialFilesystems(ctx context.Context) error {
 	i.Logger.Info("Mounting special filesystems for chroot")
 
 	mountPoints := []struct {
@@ -85,47 +240,10 @@ func (i *Installer) mountSpecialFilesystems() error {
 	for _, mp := range mountPoints {
 		target := filepath.Join(i.Config.Installation.MountPoint, mp.target)
 		args := append(mp.options, mp.source, target)
-		if err := utils.RunCommand(i.Logger, "mount", args...); err != nil {
+		if err := i.Runner.Run(ctx, "mount", args...); err != nil {
 			return fmt.Errorf("failed to mount %s: %v", mp.target, err)
 		}
-	}
-
-	return nil
-}
-
-func (i *Installer) configureSystem() error {
-	i.Logger.Info("Configuring system")
-
-	if err := i.generateFstab(); err != nil {
-		return err
-	}
-
-	if err := i.mountSpecialFilesystems(); err != nil {
-		return fmt.Errorf("failed to mount special filesystems: %v", err)
-	}
-
-	if err := i.installAdditionalPackages(); err != nil {
-		return err
-	}
-
-	if err := i.setHostname(); err != nil {
-		return err
-	}
-
-	if err := i.configureLocale(); err != nil {
-		return err
-	}
-
-	if err := i.configureNetwork(); err != nil {
-		return err
-	}
-
-	if err := i.configureUsers(); err != nil {
-		return err
-	}
-
-	if err := i.installBootloader(); err != nil {
-		return err
+		i.trackMount(target)
 	}
 
 	return nil
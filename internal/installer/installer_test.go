@@ -0,0 +1,68 @@
+package installer
+
+import (
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+	"github.com/zinrai/debinstaller-go/internal/utils"
+)
+
+const singleDisk = `devices:
+  - /dev/sda
+bootloader:
+  type: bios
+partitions:
+  - {type: bios_boot, size: 2M}
+  - {type: boot, size: 10G, filesystem: ext4, mount_point: /}
+`
+
+// newTestInstaller returns an installer for the storage section storage,
+// running its commands through a FakeRunner. The state file is kept in a
+// temporary directory.
+func newTestInstaller(t *testing.T, storage string) (*Installer, *utils.FakeRunner) {
+	t.Helper()
+
+	var cfg config.Config
+	if err := yaml.Unmarshal([]byte(storage), &cfg.Storage); err != nil {
+		t.Fatal(err)
+	}
+	cfg.System.Hostname = "debian-server"
+	cfg.Network = config.NetworkConfig{Interface: "ens3", Type: "dhcp"}
+	cfg.Installation.MountPoint = "/mnt/debian"
+	cfg.Installation.Architecture = "amd64"
+	cfg.Installation.DebianVersion = "bookworm"
+
+	dir := t.TempDir()
+	cfg.LogFile = filepath.Join(dir, "install.log")
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	logger := utils.NewLogger(cfg.LogFile)
+	t.Cleanup(logger.Close)
+
+	runner := utils.NewFakeRunner()
+	return NewInstaller(&cfg, logger, runner), runner
+}
+
+// commandLines returns the command lines run through runner, in order.
+func commandLines(runner *utils.FakeRunner) []string {
+	var lines []string
+	for _, op := range runner.Operations {
+		if op.Kind == utils.OperationRun {
+			lines = append(lines, op.CommandLine())
+		}
+	}
+	return lines
+}
+
+// indexOf returns the position of line in lines, or -1.
+func indexOf(lines []string, line string) int {
+	for idx, l := range lines {
+		if l == line {
+			return idx
+		}
+	}
+	return -1
+}
@@ -0,0 +1,291 @@
+package installer
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+// layout is the storage configuration resolved into device paths.
+type layout struct {
+	disks        []diskLayout
+	raidArrays   []raidArrayLayout
+	encrypted    []encryptedLayout
+	volumeGroups []volumeGroupLayout
+	// filesystems lists every device to format, in creation order:
+	// partitions and whole disks first, then RAID arrays, then logical
+	// volumes.
+	filesystems []filesystem
+}
+
+type diskLayout struct {
+	device     string
+	table      string
+	partitions []partitionLayout
+	// preserve keeps the partition table, or the filesystem on the whole
+	// disk.
+	preserve bool
+}
+
+type partitionLayout struct {
+	config.Partition
+	number int
+	path   string
+}
+
+type raidArrayLayout struct {
+	config.RaidArray
+	path    string
+	members []string
+	// preserve assembles the existing array instead of creating it.
+	preserve bool
+}
+
+// encryptedLayout is a LUKS container on device, unlocked as path.
+type encryptedLayout struct {
+	*config.Encryption
+	device string
+	path   string
+	// preserve opens the existing container instead of formatting it.
+	preserve bool
+}
+
+type volumeGroupLayout struct {
+	name            string
+	physicalVolumes []string
+	logicalVolumes  []config.LogicalVolume
+	preserve        bool
+}
+
+// filesystem is a block device that is formatted and, with a mount point
+// or subvolumes, mounted into the target.
+type filesystem struct {
+	device string
+	config.FilesystemConfig
+}
+
+// mount is a filesystem or btrfs subvolume mounted into the target.
+type mount struct {
+	device     string
+	fsType     string
+	mountPoint string
+	options    []string
+}
+
+func (i *Installer) layout() *layout {
+	l := &layout{}
+	pvs := make(map[string][]string)
+	members := make(map[string][]string)
+
+	// Devices below a kept volume group or filesystem are kept as well
+	volumeGroups := i.Config.Storage.VolumeGroupLayouts()
+	preservedVGs := make(map[string]bool)
+	for _, vg := range volumeGroups {
+		preservedVGs[vg.Name] = vg.Preserve
+	}
+
+	for _, disk := range i.Config.Storage.DiskLayouts() {
+		dl := diskLayout{
+			device:   disk.Device,
+			table:    disk.Table,
+			preserve: disk.Preserve || disk.Preserved(),
+		}
+		for idx, part := range disk.Partitions {
+			number := partitionNumber(disk, idx)
+			pl := partitionLayout{
+				Partition: part,
+				number:    number,
+				path:      partitionPath(disk.Device, number),
+			}
+			dl.partitions = append(dl.partitions, pl)
+			preserve := part.Preserved() || (part.Type == config.PartitionTypeLvmPV && preservedVGs[part.VolumeGroup])
+			device := l.unlocked(pl.path, part.Encryption, preserve)
+
+			switch part.Type {
+			case config.PartitionTypeLvmPV:
+				pvs[part.VolumeGroup] = append(pvs[part.VolumeGroup], device)
+			case config.PartitionTypeRaid:
+				members[part.RaidArray] = append(members[part.RaidArray], pl.path)
+			case config.PartitionTypeBiosBoot:
+			default:
+				if part.Filesystem != "" {
+					l.filesystems = append(l.filesystems, filesystem{
+						device:           device,
+						FilesystemConfig: part.FilesystemConfig,
+					})
+				}
+			}
+		}
+		if disk.Filesystem != "" {
+			l.filesystems = append(l.filesystems, filesystem{
+				device:           disk.Device,
+				FilesystemConfig: disk.FilesystemConfig,
+			})
+		}
+		l.disks = append(l.disks, dl)
+	}
+
+	for _, array := range i.Config.Storage.Raid {
+		al := raidArrayLayout{
+			RaidArray: array,
+			path:      raidArrayPath(array.Name),
+			members:   members[array.Name],
+			preserve:  array.Preserved() || preservedVGs[array.VolumeGroup],
+		}
+		l.raidArrays = append(l.raidArrays, al)
+		device := l.unlocked(al.path, array.Encryption, al.preserve)
+
+		if array.VolumeGroup != "" {
+			pvs[array.VolumeGroup] = append(pvs[array.VolumeGroup], device)
+		} else if array.Filesystem != "" {
+			l.filesystems = append(l.filesystems, filesystem{
+				device:           device,
+				FilesystemConfig: array.FilesystemConfig,
+			})
+		}
+	}
+
+	for _, vg := range volumeGroups {
+		l.volumeGroups = append(l.volumeGroups, volumeGroupLayout{
+			name:            vg.Name,
+			physicalVolumes: pvs[vg.Name],
+			logicalVolumes:  vg.LogicalVolumes,
+			preserve:        vg.Preserve,
+		})
+		for _, lv := range vg.LogicalVolumes {
+			l.filesystems = append(l.filesystems, filesystem{
+				device:           logicalVolumePath(vg.Name, lv.Name),
+				FilesystemConfig: lv.FilesystemConfig,
+			})
+		}
+	}
+
+	return l
+}
+
+// partitionNumber returns the number of the idx-th partition of disk. When
+// an msdos table has more partitions than primary slots, the extended
+// partition takes the last slot and logical partitions are numbered from 5.
+func partitionNumber(disk config.Disk, idx int) int {
+	if disk.LogicalPartition(idx) {
+		return idx + 2
+	}
+	return idx + 1
+}
+
+// logicalPartitions returns the number of logical partitions of an msdos
+// table.
+func (d diskLayout) logicalPartitions() int {
+	count := 0
+	for _, partition := range d.partitions {
+		if partition.number > config.MsdosPrimaryLimit {
+			count++
+		}
+	}
+	return count
+}
+
+// unlocked records the LUKS container enc on device, if any, and returns
+// the device that holds the filesystem or physical volume. preserve keeps
+// an existing container.
+func (l *layout) unlocked(device string, enc *config.Encryption, preserve bool) string {
+	if enc == nil {
+		return device
+	}
+
+	el := encryptedLayout{
+		Encryption: enc,
+		device:     device,
+		path:       mappingPath(enc.Name),
+		preserve:   preserve,
+	}
+	l.encrypted = append(l.encrypted, el)
+	return el.path
+}
+
+// mounts lists everything mounted into the target, ordered so that every
+// mount point comes after the one it is nested in.
+func (l *layout) mounts() []mount {
+	var mounts []mount
+	for _, fs := range l.filesystems {
+		if fs.MountPoint != "" {
+			mounts = append(mounts, mount{
+				device:     fs.device,
+				fsType:     fs.Filesystem,
+				mountPoint: fs.MountPoint,
+				options:    fs.MountOptions,
+			})
+		}
+
+		for _, sub := range fs.Subvolumes {
+			if sub.MountPoint == "" {
+				continue
+			}
+			// Options of the filesystem apply to each of its subvolumes
+			options := []string{"subvol=" + sub.Name}
+			if sub.Compression != "" {
+				options = append(options, "compress="+sub.Compression)
+			}
+			options = append(options, fs.MountOptions...)
+			options = append(options, sub.MountOptions...)
+			mounts = append(mounts, mount{
+				device:     fs.device,
+				fsType:     fs.Filesystem,
+				mountPoint: sub.MountPoint,
+				options:    options,
+			})
+		}
+	}
+
+	sort.SliceStable(mounts, func(a, b int) bool {
+		return len(mounts[a].mountPoint) < len(mounts[b].mountPoint)
+	})
+	return mounts
+}
+
+// mountFor returns the mount holding path in the target, the one with the
+// longest mount point containing it.
+func (l *layout) mountFor(path string) (mount, bool) {
+	var found mount
+	ok := false
+	for _, m := range l.mounts() {
+		rel, err := filepath.Rel(m.mountPoint, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if !ok || len(m.mountPoint) > len(found.mountPoint) {
+			found, ok = m, true
+		}
+	}
+	return found, ok
+}
+
+// usesFilesystem reports whether any device is formatted with fsType.
+func (l *layout) usesFilesystem(fsType string) bool {
+	for _, fs := range l.filesystems {
+		if fs.Filesystem == fsType {
+			return true
+		}
+	}
+	return false
+}
+
+// biosBootDisks returns the devices of the disks GRUB is installed on for
+// BIOS boot: those holding members of bootArrays, the RAID arrays with the
+// filesystem GRUB boots from, or without such arrays, those with a bios_boot
+// partition or a bootable partition on an msdos table.
+func (l *layout) biosBootDisks(bootArrays []string) []string {
+	var devices []string
+	for _, disk := range l.disks {
+		for _, part := range disk.partitions {
+			if len(bootArrays) > 0 && part.Type == config.PartitionTypeRaid && contains(bootArrays, part.RaidArray) ||
+				len(bootArrays) == 0 && (part.Type == config.PartitionTypeBiosBoot || part.Bootable) {
+				devices = append(devices, disk.device)
+				break
+			}
+		}
+	}
+	return devices
+}
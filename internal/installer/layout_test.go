@@ -0,0 +1,169 @@
+package installer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+func TestPartitionNumber(t *testing.T) {
+	partitions := func(n int) []config.Partition {
+		return make([]config.Partition, n)
+	}
+
+	tests := []struct {
+		name string
+		disk config.Disk
+		want []int
+	}{
+		{
+			name: "gpt",
+			disk: config.Disk{Partitions: partitions(6)},
+			want: []int{1, 2, 3, 4, 5, 6},
+		},
+		{
+			name: "msdos with four primary partitions",
+			disk: config.Disk{Table: config.TableMsdos, Partitions: partitions(4)},
+			want: []int{1, 2, 3, 4},
+		},
+		{
+			name: "msdos with logical partitions",
+			disk: config.Disk{Table: config.TableMsdos, Partitions: partitions(6)},
+			want: []int{1, 2, 3, 5, 6, 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for idx := range tt.disk.Partitions {
+				got = append(got, partitionNumber(tt.disk, idx))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("partition numbers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLayoutPartitionPaths(t *testing.T) {
+	i, _ := newTestInstaller(t, `disks:
+  - device: /dev/nvme0n1
+    table: msdos
+    partitions:
+      - {type: linux, size: 1G}
+      - {type: linux, size: 1G}
+      - {type: linux, size: 1G}
+      - {type: linux, size: 1G}
+      - {type: linux, size: remaining}
+`)
+
+	var got []string
+	for _, partition := range i.layout().disks[0].partitions {
+		got = append(got, partition.path)
+	}
+	want := []string{"/dev/nvme0n1p1", "/dev/nvme0n1p2", "/dev/nvme0n1p3", "/dev/nvme0n1p5", "/dev/nvme0n1p6"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("partition paths = %v, want %v", got, want)
+	}
+	if n := i.layout().disks[0].logicalPartitions(); n != 2 {
+		t.Errorf("logicalPartitions() = %d, want 2", n)
+	}
+}
+
+func TestLayoutLegacyDevices(t *testing.T) {
+	i, _ := newTestInstaller(t, `devices:
+  - /dev/sda
+  - /dev/sdb
+bootloader:
+  type: bios
+partitions:
+  - {type: bios_boot, size: 2M}
+  - {type: boot, size: 1G, filesystem: ext4, mount_point: /boot}
+  - type: lvm_pv
+    size: 20G
+    volume_group: vg0
+    logical_volumes:
+      - {name: root, size: 10G, filesystem: ext4, mount_point: /}
+`)
+	l := i.layout()
+
+	// Every device is partitioned alike, the first one holds the data
+	if len(l.disks) != 2 || len(l.disks[1].partitions) != 3 {
+		t.Fatalf("disks = %+v, want two disks with three partitions each", l.disks)
+	}
+	var got []string
+	for _, fs := range l.filesystems {
+		got = append(got, fs.device)
+	}
+	if want := []string{"/dev/sda2", "/dev/vg0/root"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filesystems on %v, want %v", got, want)
+	}
+	if pvs := l.volumeGroups[0].physicalVolumes; !reflect.DeepEqual(pvs, []string{"/dev/sda3"}) {
+		t.Errorf("physical volumes = %v, want [/dev/sda3]", pvs)
+	}
+	if got := l.biosBootDisks(nil); !reflect.DeepEqual(got, []string{"/dev/sda", "/dev/sdb"}) {
+		t.Errorf("biosBootDisks() = %v", got)
+	}
+}
+
+func TestBiosBootDisks(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    []string
+	}{
+		{
+			name: "bios_boot and bootable partitions",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: bios_boot, size: 2M}
+      - {type: linux, size: remaining, filesystem: ext4, mount_point: /}
+  - device: /dev/sdb
+    table: msdos
+    partitions:
+      - {type: linux, size: remaining, bootable: true, filesystem: ext4, mount_point: /srv}
+  - device: /dev/sdc
+    partitions:
+      - {type: linux, size: remaining, filesystem: ext4, mount_point: /home}
+`,
+			want: []string{"/dev/sda", "/dev/sdb"},
+		},
+		{
+			name: "disks of the boot array only",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: bios_boot, size: 2M}
+      - {type: raid, size: remaining, raid_array: md0}
+  - device: /dev/sdb
+    partitions:
+      - {type: bios_boot, size: 2M}
+      - {type: raid, size: remaining, raid_array: md0}
+  - device: /dev/sdc
+    partitions:
+      - {type: bios_boot, size: 2M}
+      - {type: raid, size: remaining, raid_array: md1}
+  - device: /dev/sdd
+    partitions:
+      - {type: raid, size: remaining, raid_array: md1}
+raid:
+  - {name: md0, level: 1, filesystem: ext4, mount_point: /}
+  - {name: md1, level: 1, filesystem: ext4, mount_point: /srv}
+`,
+			want: []string{"/dev/sda", "/dev/sdb"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, _ := newTestInstaller(t, tt.storage)
+			got := i.layout().biosBootDisks(i.Config.Storage.BootRaidArrays())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("biosBootDisks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// keyDir is where cryptsetup looks up key files of crypttab entries in the
+// target.
+const keyDir = "/etc/cryptsetup-keys.d"
+
+func (i *Installer) setupEncryption(ctx context.Context) error {
+	encrypted := i.layout().encrypted
+	if len(encrypted) == 0 {
+		return nil // No encryption setup needed
+	}
+
+	i.Logger.Info("Setting up encryption")
+
+	for _, enc := range encrypted {
+		// Close a mapping left over from a previous attempt
+		if err := i.Runner.Run(ctx, "cryptsetup", "close", enc.Name); err != nil {
+			i.Logger.Info("No existing mapping to close")
+		}
+
+		// Kept containers are only opened
+		if !enc.preserve {
+			if err := i.cryptsetup(ctx, enc, "luksFormat", "--type", "luks2", "--batch-mode", enc.device); err != nil {
+				return fmt.Errorf("failed to format LUKS container on %s: %v", enc.device, err)
+			}
+		}
+
+		if err := i.cryptsetup(ctx, enc, "open", "--type", "luks2", enc.device, enc.Name); err != nil {
+			return fmt.Errorf("failed to open LUKS container on %s: %v", enc.device, err)
+		}
+		i.trackMapping(enc.Name)
+	}
+
+	return i.settleDevices(ctx)
+}
+
+// openEncryption unlocks the containers that teardown closed after a
+// previous run. Mappings already open in this run are left alone.
+func (i *Installer) openEncryption(ctx context.Context) error {
+	opened := false
+	for _, enc := range i.layout().encrypted {
+		if i.mappingTracked(enc.Name) {
+			continue
+		}
+
+		if err := i.cryptsetup(ctx, enc, "open", "--type", "luks2", enc.device, enc.Name); err != nil {
+			return fmt.Errorf("failed to open LUKS container on %s: %v", enc.device, err)
+		}
+		i.trackMapping(enc.Name)
+		opened = true
+	}
+
+	if !opened {
+		return nil
+	}
+	return i.settleDevices(ctx)
+}
+
+// cryptsetup runs a cryptsetup action with the key of enc, passing a
+// passphrase on standard input so it never appears on the command line.
+func (i *Installer) cryptsetup(ctx context.Context, enc encryptedLayout, action string, args ...string) error {
+	if enc.Keyfile != "" {
+		args = append([]string{action, "--key-file", enc.Keyfile}, args...)
+		return i.Runner.Run(ctx, "cryptsetup", args...)
+	}
+	return i.Runner.RunWithInput(ctx, enc.Passphrase, "cryptsetup", append([]string{action}, args...)...)
+}
+
+// generateCrypttab writes /etc/crypttab so the containers are unlocked at
+// boot, and installs the key files it refers to.
+func (i *Installer) generateCrypttab(ctx context.Context) error {
+	encrypted := i.layout().encrypted
+	if len(encrypted) == 0 {
+		return nil
+	}
+
+	i.Logger.Info("Generating crypttab")
+
+	target := i.Config.Installation.MountPoint
+	var b strings.Builder
+	b.WriteString("# <target name> <source device> <key file> <options>\n")
+
+	for _, enc := range encrypted {
+		uuid, err := i.deviceUUID(ctx, enc.device)
+		if err != nil {
+			return err
+		}
+
+		// Passphrase containers are asked for at boot
+		key := "none"
+		if enc.Keyfile != "" {
+			key = filepath.Join(keyDir, enc.Name+".key")
+			if err := i.Runner.Run(ctx, "install", "-D", "-m", "0400", enc.Keyfile, filepath.Join(target, key)); err != nil {
+				return fmt.Errorf("failed to install key file for %s: %v", enc.Name, err)
+			}
+		}
+
+		fmt.Fprintf(&b, "%s UUID=%s %s luks\n", enc.Name, uuid, key)
+	}
+
+	if err := i.Runner.WriteFile(ctx, filepath.Join(target, "etc/crypttab"), []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write crypttab: %v", err)
+	}
+
+	return nil
+}
+
+// configureKeyfileInitramfs makes cryptsetup-initramfs copy the key files
+// into the initramfs, so containers unlocked by a key file, including the
+// root filesystem, open without a prompt. The initramfs is made readable by
+// root only, since it then holds the keys.
+func (i *Installer) configureKeyfileInitramfs(ctx context.Context) error {
+	target := i.Config.Installation.MountPoint
+	hookPath := filepath.Join(target, "etc/cryptsetup-initramfs/conf-hook")
+
+	content, err := i.Runner.ReadFile(ctx, hookPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read cryptsetup-initramfs configuration: %v", err)
+	}
+
+	pattern := fmt.Sprintf("KEYFILE_PATTERN=\"%s/*.key\"\n", keyDir)
+	if !strings.Contains(string(content), pattern) {
+		if err := i.Runner.WriteFile(ctx, hookPath, []byte(string(content)+pattern), 0644); err != nil {
+			return fmt.Errorf("failed to write cryptsetup-initramfs configuration: %v", err)
+		}
+	}
+
+	if err := i.Runner.WriteFile(ctx, filepath.Join(target, "etc/initramfs-tools/conf.d/umask"), []byte("UMASK=0077\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write initramfs umask: %v", err)
+	}
+
+	return nil
+}
@@ -1,11 +1,11 @@
 package installer
 
 import (
+	"context"
 	"fmt"
-	"os"
 )
 
-func (i *Installer) configureNetwork() error {
+func (i *Installer) configureNetwork(ctx context.Context) error {
 	i.Logger.Info("Configuring network")
 
 	interfacesDir := i.Config.Installation.MountPoint + "/etc/network/interfaces.d"
@@ -27,7 +27,7 @@ iface %s inet static
 		return fmt.Errorf("unsupported network type: %s", i.Config.Network.Type)
 	}
 
-	if err := os.WriteFile(fmt.Sprintf("%s/%s", interfacesDir, i.Config.Network.Interface),
+	if err := i.Runner.WriteFile(ctx, fmt.Sprintf("%s/%s", interfacesDir, i.Config.Network.Interface),
 		[]byte(networkConfig), 0644); err != nil {
 		return fmt.Errorf("failed to write interface configuration: %v", err)
 	}
@@ -0,0 +1,268 @@
+package installer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// requirement is the host state a phase depends on.
+type requirement int
+
+const (
+	requiresNothing requirement = iota
+	// requiresPartitions needs the partitions created by the partition phase.
+	requiresPartitions
+	// requiresArrays needs the partitions and the RAID arrays created by the
+	// raid phase.
+	requiresArrays
+	// requiresMappings additionally needs the LUKS containers opened by the
+	// luks phase.
+	requiresMappings
+	// requiresVolumes additionally needs the logical volumes created by the
+	// lvm phase.
+	requiresVolumes
+	// requiresTarget needs the target filesystems mounted by the mount phase.
+	requiresTarget
+	// requiresChroot needs the mounted target with /dev, /proc and /sys
+	// bound into it.
+	requiresChroot
+)
+
+// phase is a named, checkpointed step of the installation.
+type phase struct {
+	name     string
+	run      func(ctx context.Context) error
+	requires requirement
+	// always phases run on resume even if they completed before, because
+	// they restore host state released by teardown.
+	always bool
+	// restore runs instead of run when a completed phase is skipped on
+	// resume, to bring back devices released by teardown.
+	restore func(ctx context.Context) error
+}
+
+func (i *Installer) phases() []phase {
+	return []phase{
+		{name: "partition", run: i.partitionDevices},
+		{name: "raid", run: i.setupRAID, requires: requiresPartitions, restore: i.assembleRAID},
+		{name: "luks", run: i.setupEncryption, requires: requiresArrays, restore: i.openEncryption},
+		{name: "lvm", run: i.setupLVM, requires: requiresMappings, restore: i.activateVolumeGroups},
+		{name: "mkfs", run: i.createFilesystems, requires: requiresVolumes},
+		{name: "mount", run: i.mountFilesystems, requires: requiresVolumes, always: true},
+		{name: "debootstrap", run: i.installBaseSystem, requires: requiresTarget},
+		{name: "fstab", run: i.generateFstab, requires: requiresTarget},
+		{name: "crypttab", run: i.generateCrypttab, requires: requiresTarget},
+		{name: "swap", run: i.setupSwap, requires: requiresTarget},
+		{name: "packages", run: i.installAdditionalPackages, requires: requiresChroot},
+		{name: "hostname", run: i.setHostname, requires: requiresTarget},
+		{name: "locale", run: i.configureLocale, requires: requiresChroot},
+		{name: "network", run: i.configureNetwork, requires: requiresTarget},
+		{name: "users", run: i.configureAccounts, requires: requiresChroot},
+		{name: "mdadm", run: i.configureRAID, requires: requiresTarget},
+		{name: "initramfs", run: i.updateInitramfs, requires: requiresChroot},
+		{name: "bootloader", run: i.installBootloader, requires: requiresChroot},
+	}
+}
+
+// restoreStorage assembles the arrays, opens the mappings and activates the
+// volume groups needed to satisfy req.
+func (i *Installer) restoreStorage(ctx context.Context, req requirement) error {
+	if req >= requiresArrays {
+		if err := i.assembleRAID(ctx); err != nil {
+			return err
+		}
+	}
+	if req >= requiresMappings {
+		if err := i.openEncryption(ctx); err != nil {
+			return err
+		}
+	}
+	if req >= requiresVolumes {
+		return i.activateVolumeGroups(ctx)
+	}
+	return nil
+}
+
+// PhaseNames returns the names of all phases in execution order.
+func PhaseNames() []string {
+	var names []string
+	for _, p := range (&Installer{}).phases() {
+		names = append(names, p.name)
+	}
+	return names
+}
+
+// CheckPhaseNames returns an error naming the first entry of names that is
+// not a phase.
+func CheckPhaseNames(names []string) error {
+	known := PhaseNames()
+	for _, name := range names {
+		if !contains(known, name) {
+			return fmt.Errorf("unknown phase %q (valid phases: %s)", name, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedPhases applies Only and Skip to the list of phases.
+func (i *Installer) selectedPhases() []phase {
+	var selected []phase
+	for _, p := range i.phases() {
+		if len(i.Only) > 0 && !contains(i.Only, p.name) {
+			continue
+		}
+		if contains(i.Skip, p.name) {
+			continue
+		}
+		selected = append(selected, p)
+	}
+	return selected
+}
+
+// checkRequirement verifies that state a phase depends on is already in
+// place when the phase providing it is not part of this run. The arrays,
+// mappings and volume groups that teardown releases between runs are
+// brought back first.
+func (i *Installer) checkRequirement(ctx context.Context, p phase) error {
+	target := i.Config.Installation.MountPoint
+
+	switch p.requires {
+	case requiresPartitions, requiresArrays, requiresMappings, requiresVolumes:
+		if err := i.restoreStorage(ctx, p.requires); err != nil {
+			return fmt.Errorf("phase %s requires existing storage: %v", p.name, err)
+		}
+		for _, device := range i.blockDevices(p.requires) {
+			if err := i.Runner.Run(ctx, "test", "-b", device); err != nil {
+				return fmt.Errorf("phase %s requires block device %s, which does not exist", p.name, device)
+			}
+		}
+	case requiresTarget, requiresChroot:
+		if err := i.Runner.Run(ctx, "mountpoint", "-q", target); err != nil {
+			return fmt.Errorf("phase %s requires the target to be mounted at %s; run the mount phase or mount it manually", p.name, target)
+		}
+		if p.requires == requiresTarget {
+			break
+		}
+		for _, dir := range []string{"dev", "proc", "sys"} {
+			path := filepath.Join(target, dir)
+			if err := i.Runner.Run(ctx, "mountpoint", "-q", path); err != nil {
+				return fmt.Errorf("phase %s requires %s to be mounted for chroot", p.name, path)
+			}
+		}
+	}
+
+	return nil
+}
+
+const defaultStateFile = "/tmp/debinstaller-state.json"
+
+// installState records the phases completed so far, so a failed
+// installation can be resumed.
+type installState struct {
+	// ConfigHash identifies the configuration the phases were run with.
+	ConfigHash string   `json:"config_hash"`
+	Completed  []string `json:"completed"`
+}
+
+func (s *installState) done(name string) bool {
+	for _, completed := range s.Completed {
+		if completed == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Installer) partial() bool {
+	return len(i.Only) > 0 || len(i.Skip) > 0
+}
+
+func (i *Installer) stateFile() string {
+	if i.Config.StateFile != "" {
+		return i.Config.StateFile
+	}
+	return defaultStateFile
+}
+
+func (i *Installer) configHash() (string, error) {
+	data, err := json.Marshal(i.Config)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// loadState reads the state of a previous run for resuming. The state file
+// is installer bookkeeping on the live system, so it is accessed directly
+// rather than through the Runner.
+func (i *Installer) loadState() (*installState, error) {
+	hash, err := i.configHash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash configuration: %v", err)
+	}
+
+	if !i.Resume {
+		return &installState{ConfigHash: hash}, nil
+	}
+
+	data, err := os.ReadFile(i.stateFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %v", err)
+	}
+
+	var state installState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %v", i.stateFile(), err)
+	}
+
+	if state.ConfigHash != hash {
+		return nil, fmt.Errorf("configuration changed since the state file %s was written; start over without -resume", i.stateFile())
+	}
+
+	return &state, nil
+}
+
+// saveState records progress. Runs restricted with Only or Skip are for
+// debugging an existing target and leave the state file alone.
+func (i *Installer) saveState(state *installState) error {
+	if i.planning || i.partial() {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(i.stateFile(), append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	return nil
+}
+
+func (i *Installer) removeState() error {
+	if i.planning || i.partial() {
+		return nil
+	}
+
+	if err := os.Remove(i.stateFile()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove state file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+func TestSelectedPhases(t *testing.T) {
+	tests := []struct {
+		name string
+		only []string
+		skip []string
+		want []string
+	}{
+		{
+			name: "all",
+			want: PhaseNames(),
+		},
+		{
+			name: "only",
+			only: []string{"bootloader", "mount"},
+			want: []string{"mount", "bootloader"},
+		},
+		{
+			name: "skip",
+			skip: []string{"partition", "raid", "luks", "lvm", "mkfs", "packages"},
+			want: []string{"mount", "debootstrap", "fstab", "crypttab", "swap", "hostname", "locale", "network", "users", "mdadm", "initramfs", "bootloader"},
+		},
+		{
+			name: "only and skip",
+			only: []string{"fstab", "hostname"},
+			skip: []string{"hostname"},
+			want: []string{"fstab"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, _ := newTestInstaller(t, singleDisk)
+			i.Only, i.Skip = tt.only, tt.skip
+
+			var got []string
+			for _, p := range i.selectedPhases() {
+				got = append(got, p.name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectedPhases() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPhaseNames(t *testing.T) {
+	if err := CheckPhaseNames([]string{"mount", "bootloader"}); err != nil {
+		t.Errorf("CheckPhaseNames() failed: %v", err)
+	}
+	if err := CheckPhaseNames([]string{"mount", "grub"}); err == nil || !strings.Contains(err.Error(), "grub") {
+		t.Errorf("CheckPhaseNames() = %v, want error naming grub", err)
+	}
+}
+
+// readState returns the phases recorded in the state file of i.
+func readState(t *testing.T, i *Installer) []string {
+	t.Helper()
+	data, err := os.ReadFile(i.stateFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state installState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state.Completed
+}
+
+func TestResume(t *testing.T) {
+	i, runner := newTestInstaller(t, singleDisk)
+	i.Config.Users = []config.User{{Username: "admin", Credentials: config.Credentials{Password: "secret"}}}
+
+	hash, err := i.configHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	completed := []string{"partition", "raid", "luks", "lvm", "mkfs", "mount", "debootstrap", "fstab"}
+	if err := i.saveState(&installState{ConfigHash: hash, Completed: completed}); err != nil {
+		t.Fatal(err)
+	}
+
+	runner.Respond("chroot /mnt/debian getent passwd admin", "", errors.New("exit status 2"))
+	runner.Respond("chroot /mnt/debian useradd -m -s /bin/bash admin", "", errors.New("exit status 1"))
+
+	i.Resume = true
+	err = i.Install(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "phase users failed") {
+		t.Fatalf("Install() = %v, want failure in phase users", err)
+	}
+
+	lines := commandLines(runner)
+	for _, line := range lines {
+		for _, skipped := range []string{"sgdisk ", "mkfs", "debootstrap ", "blkid "} {
+			if strings.HasPrefix(line, skipped) {
+				t.Errorf("completed phase ran %q", line)
+			}
+		}
+	}
+
+	// mount always runs, and teardown unmounts again
+	mount := indexOf(lines, "mount /dev/sda2 /mnt/debian")
+	umount := indexOf(lines, "umount /mnt/debian")
+	if mount < 0 || umount < mount {
+		t.Errorf("commands %q do not mount and unmount the target", lines)
+	}
+
+	want := append(completed, "crypttab", "swap", "packages", "hostname", "locale", "network")
+	if got := readState(t, i); !reflect.DeepEqual(got, want) {
+		t.Errorf("state = %v, want %v", got, want)
+	}
+}
+
+func TestResumeConfigChanged(t *testing.T) {
+	i, _ := newTestInstaller(t, singleDisk)
+	if err := i.saveState(&installState{ConfigHash: "stale", Completed: []string{"partition"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	i.Resume = true
+	if _, err := i.loadState(); err == nil || !strings.Contains(err.Error(), "configuration changed") {
+		t.Errorf("loadState() = %v, want configuration changed error", err)
+	}
+
+	// Without Resume the state file is ignored
+	i.Resume = false
+	state, err := i.loadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Completed) != 0 {
+		t.Errorf("loadState() completed = %v, want none", state.Completed)
+	}
+}
+
+func TestPartialRunLeavesState(t *testing.T) {
+	i, runner := newTestInstaller(t, singleDisk)
+	runner.Respond("blkid -s UUID -o value /dev/sda2", "uuid-root\n", nil)
+	i.Only = []string{"fstab"}
+
+	if err := i.Install(context.Background()); err != nil {
+		t.Fatalf("Install() failed: %v", err)
+	}
+	if _, err := os.Stat(i.stateFile()); !os.IsNotExist(err) {
+		t.Errorf("partial run wrote the state file: %v", err)
+	}
+	if indexOf(commandLines(runner), "mountpoint -q /mnt/debian") < 0 {
+		t.Error("target was not checked to be mounted")
+	}
+}
+
+func TestOnlyMountKeepsTarget(t *testing.T) {
+	i, runner := newTestInstaller(t, `devices:
+  - /dev/sda
+bootloader:
+  type: bios
+partitions:
+  - {type: bios_boot, size: 2M}
+  - type: lvm_pv
+    size: 20G
+    volume_group: vg0
+    logical_volumes:
+      - {name: root, size: 10G, filesystem: ext4, mount_point: /}
+`)
+	i.Only = []string{"mount"}
+
+	if err := i.Install(context.Background()); err != nil {
+		t.Fatalf("Install() failed: %v", err)
+	}
+
+	lines := commandLines(runner)
+	activate := indexOf(lines, "vgchange -ay vg0")
+	check := indexOf(lines, "test -b /dev/vg0/root")
+	if activate < 0 || check < activate {
+		t.Errorf("volume group not activated before checking its volumes: %q", lines)
+	}
+	for _, want := range []string{"mount /dev/vg0/root /mnt/debian", "mount --bind /dev /mnt/debian/dev"} {
+		if indexOf(lines, want) < 0 {
+			t.Errorf("missing %q in %q", want, lines)
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "umount ") || line == "vgchange -an vg0" {
+			t.Errorf("target torn down: %q", line)
+		}
+	}
+}
+
+func TestOnlyMountFailureTearsDown(t *testing.T) {
+	i, runner := newTestInstaller(t, `devices:
+  - /dev/sda
+bootloader:
+  type: bios
+partitions:
+  - {type: bios_boot, size: 2M}
+  - {type: boot, size: 1G, filesystem: ext4, mount_point: /boot}
+  - {type: boot, size: 10G, filesystem: ext4, mount_point: /}
+`)
+	runner.Respond("mount /dev/sda2 /mnt/debian/boot", "", errors.New("exit status 32"))
+	i.Only = []string{"mount"}
+
+	if err := i.Install(context.Background()); err == nil {
+		t.Fatal("Install() succeeded")
+	}
+
+	lines := commandLines(runner)
+	if indexOf(lines, "umount /mnt/debian") < 0 {
+		t.Errorf("target not unmounted after a failure: %q", lines)
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "mount --bind") {
+			t.Errorf("special filesystems mounted after a failure: %q", line)
+		}
+	}
+}
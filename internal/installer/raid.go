@@ -0,0 +1,118 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+const defaultRaidMetadata = "1.2"
+
+func (i *Installer) setupRAID(ctx context.Context) error {
+	arrays := i.layout().raidArrays
+	if len(arrays) == 0 {
+		return nil // No RAID setup needed
+	}
+
+	i.Logger.Info("Setting up RAID")
+
+	for _, array := range arrays {
+		// Stop an existing array left over from a previous attempt
+		if err := i.Runner.Run(ctx, "mdadm", "--stop", array.path); err != nil {
+			i.Logger.Info("No existing RAID array to stop")
+		}
+
+		if array.preserve {
+			if err := i.assembleArray(ctx, array); err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, member := range array.members {
+			// Remove stale superblocks so mdadm does not prompt
+			if err := i.Runner.Run(ctx, "mdadm", "--zero-superblock", "--force", member); err != nil {
+				i.Logger.Info("No existing RAID superblock to remove")
+			}
+		}
+
+		metadata := array.Metadata
+		if metadata == "" {
+			metadata = defaultRaidMetadata
+		}
+
+		args := []string{"--create", array.path, "--run",
+			"--level=" + array.Level,
+			"--raid-devices=" + strconv.Itoa(len(array.members)),
+			"--metadata=" + metadata,
+		}
+		args = append(args, array.members...)
+		if err := i.Runner.Run(ctx, "mdadm", args...); err != nil {
+			return fmt.Errorf("failed to create RAID array %s: %v", array.path, err)
+		}
+		i.trackRaidArray(array.path)
+	}
+
+	return i.settleDevices(ctx)
+}
+
+// assembleRAID starts the arrays that teardown stopped after a previous
+// run. Arrays already running in this run are left alone.
+func (i *Installer) assembleRAID(ctx context.Context) error {
+	assembled := false
+	for _, array := range i.layout().raidArrays {
+		if i.raidArrayTracked(array.path) {
+			continue
+		}
+
+		if err := i.assembleArray(ctx, array); err != nil {
+			return err
+		}
+		assembled = true
+	}
+
+	if !assembled {
+		return nil
+	}
+	return i.settleDevices(ctx)
+}
+
+// assembleArray starts an existing array from its members.
+func (i *Installer) assembleArray(ctx context.Context, array raidArrayLayout) error {
+	args := append([]string{"--assemble", array.path}, array.members...)
+	if err := i.Runner.Run(ctx, "mdadm", args...); err != nil {
+		return fmt.Errorf("failed to assemble RAID array %s: %v", array.path, err)
+	}
+	i.trackRaidArray(array.path)
+	return nil
+}
+
+// configureRAID records the arrays in the target's mdadm.conf, so they are
+// assembled at boot.
+func (i *Installer) configureRAID(ctx context.Context) error {
+	if len(i.Config.Storage.Raid) == 0 {
+		return nil
+	}
+
+	i.Logger.Info("Configuring RAID")
+
+	// Only the configured arrays, not others the host happens to run
+	content := "# Generated by debinstaller-go\nHOMEHOST <system>\nMAILADDR root\n\n"
+	for _, array := range i.layout().raidArrays {
+		line, err := i.Runner.RunWithOutput(ctx, "mdadm", "--detail", "--brief", array.path)
+		if err != nil {
+			return fmt.Errorf("failed to describe RAID array %s: %v", array.path, err)
+		}
+		content += string(line)
+	}
+	confPath := filepath.Join(i.Config.Installation.MountPoint, "etc/mdadm/mdadm.conf")
+	if err := i.Runner.MkdirAll(ctx, filepath.Dir(confPath), 0755); err != nil {
+		return fmt.Errorf("failed to create mdadm directory: %v", err)
+	}
+	if err := i.Runner.WriteFile(ctx, confPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write mdadm.conf: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package installer
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConfigureRAID(t *testing.T) {
+	i, runner := newTestInstaller(t, `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: raid, size: 20G, raid_array: md0}
+  - device: /dev/sdb
+    partitions:
+      - {type: raid, size: 20G, raid_array: md0}
+raid:
+  - {name: md0, level: 1, filesystem: ext4, mount_point: /}
+`)
+	runner.Respond("mdadm --detail --brief /dev/md0", "ARRAY /dev/md0 metadata=1.2 UUID=0b4f7d35:2c534b4c:9d1e5e0e:9d3c8a11\n", nil)
+
+	if err := i.configureRAID(context.Background()); err != nil {
+		t.Fatalf("configureRAID() failed: %v", err)
+	}
+
+	// Arrays of the host that are not configured are left out
+	want := "# Generated by debinstaller-go\nHOMEHOST <system>\nMAILADDR root\n\n" +
+		"ARRAY /dev/md0 metadata=1.2 UUID=0b4f7d35:2c534b4c:9d1e5e0e:9d3c8a11\n"
+	if got := string(runner.Files["/mnt/debian/etc/mdadm/mdadm.conf"]); got != want {
+		t.Errorf("mdadm.conf =\n%s\nwant\n%s", got, want)
+	}
+	if indexOf(commandLines(runner), "mdadm --detail --scan") >= 0 {
+		t.Error("mdadm.conf built from every array of the host")
+	}
+}
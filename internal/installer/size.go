@@ -0,0 +1,211 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+const mib = 1 << 20
+
+// gptOverhead is the space of a GPT disk that partitions cannot use: the
+// first MiB, where sgdisk aligns the first partition, and the backup GPT at
+// the end.
+const gptOverhead = 2 * mib
+
+// overhead returns the space of disk that partitions cannot use. On msdos
+// tables, sfdisk aligns the first partition and every logical partition to
+// 1 MiB, leaving a gap for its boot record.
+func (d diskLayout) overhead() uint64 {
+	if d.table == config.TableMsdos {
+		return mib * uint64(1+d.logicalPartitions())
+	}
+	return gptOverhead
+}
+
+// fillsRest reports whether a size takes all the free space without bounds,
+// which sgdisk and lvcreate do without knowing how much space there is.
+func fillsRest(size config.Size, bounds config.SizeBounds) bool {
+	return size.Kind == config.SizePercentFree && size.Percent == 100 && !bounds.Set()
+}
+
+// partitionEnds returns the end of each partition of disk: "+SIZE", or "0"
+// for a last partition taking the rest of the disk. The disk size is only
+// read when a relative size needs it.
+func (i *Installer) partitionEnds(ctx context.Context, disk diskLayout) ([]string, error) {
+	sizes := make([]config.Size, len(disk.partitions))
+	last := len(disk.partitions) - 1
+	needDisk := false
+	for idx, partition := range disk.partitions {
+		size, err := config.ParseSizeSpec(partition.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of partition %d on %s: %v", partition.number, disk.device, err)
+		}
+		sizes[idx] = size
+		if size.Relative() && !(idx == last && fillsRest(size, partition.SizeBounds)) {
+			needDisk = true
+		}
+	}
+
+	ends := make([]string, len(sizes))
+	if !needDisk {
+		for idx, size := range sizes {
+			if size.Relative() {
+				ends[idx] = "0"
+			} else {
+				ends[idx] = "+" + size.String()
+			}
+		}
+		return ends, nil
+	}
+
+	diskSize, err := i.diskSize(ctx, disk.device)
+	if err != nil {
+		if !i.planning {
+			return nil, err
+		}
+		// A plan does not know the disk size, so show the sizes unresolved
+		for idx, size := range sizes {
+			switch {
+			case idx == last && fillsRest(size, disk.partitions[idx].SizeBounds):
+				ends[idx] = "0"
+			case size.Relative():
+				ends[idx] = fmt.Sprintf("+<%s of %s>", size, disk.device)
+			default:
+				ends[idx] = "+" + size.String()
+			}
+		}
+		return ends, nil
+	}
+	if diskSize <= disk.overhead() {
+		return nil, fmt.Errorf("disk %s is too small to partition", disk.device)
+	}
+	usable := (diskSize - disk.overhead()) / mib * mib
+
+	// Absolute and percentage sizes first, then shares of what they leave
+	bytes := make([]uint64, len(sizes))
+	var used uint64
+	for idx, size := range sizes {
+		switch size.Kind {
+		case config.SizeAbsolute:
+			bytes[idx] = size.Bytes
+		case config.SizePercent:
+			bytes[idx] = disk.partitions[idx].Clamp(usable * size.Percent / 100 / mib * mib)
+		default:
+			continue
+		}
+		used += alignUp(bytes[idx])
+	}
+	if used > usable {
+		return nil, fmt.Errorf("partitions on %s need %s, but only %s is usable", disk.device, config.FormatSize(used), config.FormatSize(usable))
+	}
+
+	free := usable - used
+	for idx, size := range sizes {
+		if size.Kind != config.SizePercentFree {
+			continue
+		}
+		if idx == last && fillsRest(size, disk.partitions[idx].SizeBounds) {
+			ends[idx] = "0"
+			continue
+		}
+		bytes[idx] = disk.partitions[idx].Clamp(free * size.Percent / 100 / mib * mib)
+		used += alignUp(bytes[idx])
+	}
+	if used > usable {
+		return nil, fmt.Errorf("partitions on %s need %s, but only %s is usable", disk.device, config.FormatSize(used), config.FormatSize(usable))
+	}
+
+	for idx := range sizes {
+		if ends[idx] == "" {
+			ends[idx] = "+" + config.FormatSize(bytes[idx])
+		}
+	}
+	return ends, nil
+}
+
+// alignUp rounds bytes up to whole MiB, as sgdisk aligns the partition
+// following it.
+func alignUp(bytes uint64) uint64 {
+	return (bytes + mib - 1) / mib * mib
+}
+
+// diskSize returns the size of a disk in bytes.
+func (i *Installer) diskSize(ctx context.Context, device string) (uint64, error) {
+	output, err := i.Runner.RunWithOutput(ctx, "blockdev", "--getsize64", device)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %s: %v", device, err)
+	}
+
+	size, err := strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse size of %s: %q", device, output)
+	}
+	return size, nil
+}
+
+// creationOrder returns the logical volumes in the order to create them:
+// those taking a share of the free space last, so the space they share is
+// what the others leave.
+func creationOrder(lvs []config.LogicalVolume) []config.LogicalVolume {
+	var ordered, free []config.LogicalVolume
+	for _, lv := range lvs {
+		if size, err := config.ParseSizeSpec(lv.Size); err == nil && size.Kind == config.SizePercentFree {
+			free = append(free, lv)
+		} else {
+			ordered = append(ordered, lv)
+		}
+	}
+	return append(ordered, free...)
+}
+
+// lvcreateSize returns the lvcreate arguments sizing lv. freeTaken is the
+// share of the free space taken by the volumes created before it, since
+// lvcreate measures %FREE against the space that is still free.
+func (i *Installer) lvcreateSize(ctx context.Context, vg string, lv config.LogicalVolume, freeTaken uint64) ([]string, error) {
+	size, err := config.ParseSizeSpec(lv.Size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size of logical volume %s: %v", lv.Name, err)
+	}
+
+	var percent uint64
+	var of string
+	switch size.Kind {
+	case config.SizeAbsolute:
+		return []string{"-L", size.String()}, nil
+	case config.SizePercent:
+		percent, of = size.Percent, "VG"
+	case config.SizePercentFree:
+		percent, of = size.Percent*100/(100-freeTaken), "FREE"
+	}
+
+	if !lv.SizeBounds.Set() {
+		return []string{"-l", fmt.Sprintf("%d%%%s", percent, of)}, nil
+	}
+
+	// Bounds need the extents of the volume group
+	output, err := i.Runner.RunWithOutput(ctx, "vgs", "--noheadings", "--nosuffix", "--units", "b",
+		"-o", "vg_extent_size,vg_extent_count,vg_free_count", vg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get size of volume group %s: %v", vg, err)
+	}
+
+	var extentSize, extents, freeExtents uint64
+	if _, err := fmt.Sscan(string(output), &extentSize, &extents, &freeExtents); err != nil || extentSize == 0 {
+		if i.planning {
+			return []string{"-l", fmt.Sprintf("<%s of %s>", size, vg)}, nil
+		}
+		return nil, fmt.Errorf("failed to parse size of volume group %s: %q", vg, output)
+	}
+
+	count := extents
+	if of == "FREE" {
+		count = freeExtents
+	}
+	// Round up like lvcreate -L does
+	bytes := lv.SizeBounds.Clamp(count * percent / 100 * extentSize)
+	return []string{"-l", strconv.FormatUint((bytes+extentSize-1)/extentSize, 10)}, nil
+}
@@ -0,0 +1,229 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+func TestPartitionEnds(t *testing.T) {
+	const tenGiB = "10737418240\n"
+
+	tests := []struct {
+		name     string
+		storage  string
+		diskSize string // output of blockdev, or "" if it must not be read
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "absolute sizes and remaining",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: bios_boot, size: 2M}
+      - {type: linux, size: 1.5G}
+      - {type: linux, size: remaining}
+`,
+			want: []string{"+2M", "+1536M", "0"},
+		},
+		{
+			name: "percentage of the disk",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: linux, size: 50%}
+      - {type: linux, size: remaining}
+`,
+			diskSize: tenGiB,
+			want:     []string{"+5119M", "0"},
+		},
+		{
+			name: "percentage with bounds",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: linux, size: 10%, max_size: 512M}
+      - {type: linux, size: 1%, min_size: 1G}
+      - {type: linux, size: remaining}
+`,
+			diskSize: tenGiB,
+			want:     []string{"+512M", "+1G", "0"},
+		},
+		{
+			name: "shares of the free space",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: linux, size: 1G}
+      - {type: linux, size: 50%FREE}
+      - {type: linux, size: 50%FREE}
+`,
+			diskSize: tenGiB,
+			want:     []string{"+1G", "+4607M", "+4607M"},
+		},
+		{
+			name: "msdos overhead",
+			storage: `disks:
+  - device: /dev/sda
+    table: msdos
+    partitions:
+      - {type: linux, size: 50%}
+      - {type: linux, size: remaining}
+`,
+			diskSize: tenGiB,
+			want:     []string{"+5119M", "0"},
+		},
+		{
+			name: "too large",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: linux, size: 8G}
+      - {type: linux, size: 50%}
+`,
+			diskSize: tenGiB,
+			wantErr:  true,
+		},
+		{
+			name: "unreadable disk size",
+			storage: `disks:
+  - device: /dev/sda
+    partitions:
+      - {type: linux, size: 50%}
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, runner := newTestInstaller(t, tt.storage)
+			if tt.diskSize != "" {
+				runner.Respond("blockdev --getsize64 /dev/sda", tt.diskSize, nil)
+			} else {
+				runner.Respond("blockdev --getsize64 /dev/sda", "", errors.New("exit status 1"))
+			}
+
+			got, err := i.partitionEnds(context.Background(), i.layout().disks[0])
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("partitionEnds() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("partitionEnds() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("partitionEnds() = %v, want %v", got, tt.want)
+			}
+			if read := indexOf(commandLines(runner), "blockdev --getsize64 /dev/sda") >= 0; read != (tt.diskSize != "") {
+				t.Errorf("disk size read = %v, want %v", read, tt.diskSize != "")
+			}
+		})
+	}
+}
+
+func TestLvcreateSize(t *testing.T) {
+	// 4 MiB extents, 2559 in total, all free
+	const extents = "  4194304 2559 2559\n"
+
+	tests := []struct {
+		name      string
+		lv        config.LogicalVolume
+		freeTaken uint64
+		vgs       string
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name: "absolute",
+			lv:   config.LogicalVolume{Name: "root", Size: "20G"},
+			want: []string{"-L", "20G"},
+		},
+		{
+			name: "percentage of the volume group",
+			lv:   config.LogicalVolume{Name: "root", Size: "50%"},
+			want: []string{"-l", "50%VG"},
+		},
+		{
+			name: "remaining",
+			lv:   config.LogicalVolume{Name: "home", Size: "remaining"},
+			want: []string{"-l", "100%FREE"},
+		},
+		{
+			name:      "share of the free space after others took some",
+			lv:        config.LogicalVolume{Name: "srv", Size: "25%FREE"},
+			freeTaken: 50,
+			want:      []string{"-l", "50%FREE"},
+		},
+		{
+			name: "bounded",
+			lv: config.LogicalVolume{Name: "swap", Size: "10%",
+				SizeBounds: config.SizeBounds{MaxSize: "512M"}},
+			vgs:  extents,
+			want: []string{"-l", "128"},
+		},
+		{
+			name: "bounded rounds up to whole extents",
+			lv: config.LogicalVolume{Name: "swap", Size: "1%",
+				SizeBounds: config.SizeBounds{MinSize: "1001M"}},
+			vgs:  extents,
+			want: []string{"-l", "251"},
+		},
+		{
+			name: "bounded without volume group size",
+			lv: config.LogicalVolume{Name: "swap", Size: "10%",
+				SizeBounds: config.SizeBounds{MaxSize: "512M"}},
+			vgs:     "garbage",
+			wantErr: true,
+		},
+		{
+			name:    "invalid size",
+			lv:      config.LogicalVolume{Name: "root", Size: "20"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, runner := newTestInstaller(t, "")
+			runner.Respond("vgs --noheadings --nosuffix --units b -o vg_extent_size,vg_extent_count,vg_free_count vg0", tt.vgs, nil)
+
+			got, err := i.lvcreateSize(context.Background(), "vg0", tt.lv, tt.freeTaken)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("lvcreateSize() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lvcreateSize() failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lvcreateSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreationOrder(t *testing.T) {
+	lvs := []config.LogicalVolume{
+		{Name: "home", Size: "remaining"},
+		{Name: "root", Size: "20G"},
+		{Name: "srv", Size: "50%FREE"},
+		{Name: "var", Size: "10%"},
+	}
+
+	var got []string
+	for _, lv := range creationOrder(lvs) {
+		got = append(got, lv.Name)
+	}
+	if want := []string{"root", "var", "home", "srv"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("creationOrder() = %v, want %v", got, want)
+	}
+}
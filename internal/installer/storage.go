@@ -1,127 +1,218 @@
 package installer
 
 import (
+	"context"
 	"fmt"
-	"os"
 	"path/filepath"
-	"sort"
-	"time"
+	"strings"
 
 	"github.com/zinrai/debinstaller-go/internal/config"
-	"github.com/zinrai/debinstaller-go/internal/utils"
 )
 
-func (i *Installer) prepareStorage() error {
+func (i *Installer) partitionDevices(ctx context.Context) error {
 	i.Logger.Info("Preparing storage")
 
-	for _, device := range i.Config.Storage.Devices {
-		if err := i.partitionDevice(device); err != nil {
+	for _, disk := range i.layout().disks {
+		if err := i.partitionDevice(ctx, disk); err != nil {
 			return err
 		}
 	}
 
-	if err := i.setupLVM(); err != nil {
-		return err
-	}
+	return nil
+}
 
-	if err := i.createFilesystems(); err != nil {
-		return err
+func (i *Installer) partitionDevice(ctx context.Context, disk diskLayout) error {
+	if disk.preserve {
+		i.Logger.Info("Keeping existing partitions of device: %s", disk.device)
+		return nil
 	}
 
-	if err := i.mountFilesystems(); err != nil {
-		return err
+	if len(disk.partitions) == 0 {
+		// The filesystem goes on the whole disk, so remove any partition
+		// table and signatures that mkfs would trip over
+		i.Logger.Info("Wiping device: %s", disk.device)
+		if err := i.Runner.Run(ctx, "wipefs", "-a", disk.device); err != nil {
+			return fmt.Errorf("failed to wipe device: %v", err)
+		}
+		return i.settleDevices(ctx)
 	}
 
-	return nil
-}
+	i.Logger.Info("Partitioning device: %s", disk.device)
 
-func (i *Installer) partitionDevice(device string) error {
-	i.Logger.Info("Partitioning device: %s", device)
+	if disk.table == config.TableMsdos {
+		return i.partitionMsdos(ctx, disk)
+	}
 
 	// Clear partition table
-	if err := utils.RunCommand(i.Logger, "sgdisk", "-Z", "-o", device); err != nil {
+	if err := i.Runner.Run(ctx, "sgdisk", "-Z", "-o", disk.device); err != nil {
 		return fmt.Errorf("failed to clear partition table: %v", err)
 	}
 
-	args := []string{device}
-	for idx, partition := range i.Config.Storage.Partitions {
-		partNum := idx + 1
+	ends, err := i.partitionEnds(ctx, disk)
+	if err != nil {
+		return err
+	}
 
+	args := []string{disk.device}
+	for idx, partition := range disk.partitions {
 		// Add arguments for partition creation
-		args = append(args, "-n", fmt.Sprintf("%d::+%s", partNum, partition.Size))
+		args = append(args, "-n", fmt.Sprintf("%d::%s", partition.number, ends[idx]))
 
 		// Set the partition type
-		typeCode := getPartitionTypeCode(partition.Type)
-		args = append(args, "-t", fmt.Sprintf("%d:%s", partNum, typeCode))
+		args = append(args, "-t", fmt.Sprintf("%d:%s", partition.number, partition.GPTTypeGUID()))
+
+		if partition.PartitionLabel != "" {
+			args = append(args, "-c", fmt.Sprintf("%d:%s", partition.number, partition.PartitionLabel))
+		}
+		if partition.PartitionUUID != "" {
+			args = append(args, "-u", fmt.Sprintf("%d:%s", partition.number, partition.PartitionUUID))
+		}
+		for _, attr := range partition.Attributes {
+			bit, err := config.ParseGPTAttribute(attr)
+			if err != nil {
+				return err
+			}
+			args = append(args, "-A", fmt.Sprintf("%d:set:%d", partition.number, bit))
+		}
 	}
 
 	// Execute partitioning
-	if err := utils.RunCommand(i.Logger, "sgdisk", args...); err != nil {
+	if err := i.Runner.Run(ctx, "sgdisk", args...); err != nil {
 		return fmt.Errorf("failed to create partitions: %v", err)
 	}
 
-	time.Sleep(time.Second)
-	return nil
+	return i.settleDevices(ctx)
 }
 
-func getPartitionTypeCode(pType config.PartitionType) string {
-	switch pType {
-	case config.PartitionTypeBiosBoot:
-		return "ef02"
-	case config.PartitionTypeEfiSystem:
-		return "ef00"
-	case config.PartitionTypeLvmPV:
-		return "8e00"
-	default:
-		return "8300"
+// partitionMsdos writes an msdos partition table with sfdisk, which reads
+// the partitions as a script from standard input.
+func (i *Installer) partitionMsdos(ctx context.Context, disk diskLayout) error {
+	// Clear partition table, including a GPT left by a previous install
+	if err := i.Runner.Run(ctx, "wipefs", "-a", disk.device); err != nil {
+		return fmt.Errorf("failed to clear partition table: %v", err)
 	}
-}
 
-func (i *Installer) setupLVM() error {
-	i.Logger.Info("Setting up LVM")
+	ends, err := i.partitionEnds(ctx, disk)
+	if err != nil {
+		return err
+	}
 
-	// Find LVM PV partition
-	var lvmPartition *config.Partition
-	var partitionNumber int
-	for idx, part := range i.Config.Storage.Partitions {
-		if part.Type == config.PartitionTypeLvmPV {
-			lvmPartition = &i.Config.Storage.Partitions[idx]
-			partitionNumber = idx + 1
-			break
+	var b strings.Builder
+	b.WriteString("label: dos\n")
+	for idx, partition := range disk.partitions {
+		if partition.number == config.MsdosPrimaryLimit+1 {
+			// Extended partition over the rest of the disk, holding the
+			// logical partitions
+			b.WriteString("type=5\n")
+		}
+
+		var fields []string
+		if ends[idx] != "0" {
+			fields = append(fields, "size="+strings.TrimPrefix(ends[idx], "+"))
+		}
+		fields = append(fields, "type="+partition.MBRTypeID())
+		if partition.Bootable {
+			fields = append(fields, "bootable")
 		}
+		b.WriteString(strings.Join(fields, ", ") + "\n")
 	}
 
-	if lvmPartition == nil {
-		return nil // No LVM setup needed
+	if err := i.Runner.RunWithInput(ctx, b.String(), "sfdisk", disk.device); err != nil {
+		return fmt.Errorf("failed to create partitions: %v", err)
 	}
 
-	// Get PV device path
-	pvDevice := fmt.Sprintf("%s%d", i.Config.Storage.Devices[0], partitionNumber)
+	return i.settleDevices(ctx)
+}
 
-	// Remove existing VG if any
-	if err := utils.RunCommand(i.Logger, "vgremove", "-f", lvmPartition.VolumeGroup); err != nil {
-		i.Logger.Info("No existing volume group to remove")
+func (i *Installer) setupLVM(ctx context.Context) error {
+	volumeGroups := i.layout().volumeGroups
+	if len(volumeGroups) == 0 {
+		return nil // No LVM setup needed
 	}
 
-	// Remove existing PV if any
-	if err := utils.RunCommand(i.Logger, "pvremove", "-ff", pvDevice); err != nil {
-		i.Logger.Info("No existing physical volume to remove")
+	i.Logger.Info("Setting up LVM")
+
+	for _, vg := range volumeGroups {
+		if vg.preserve {
+			if err := i.reuseVolumeGroup(ctx, vg); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Remove existing VG if any
+		if err := i.Runner.Run(ctx, "vgremove", "-f", vg.name); err != nil {
+			i.Logger.Info("No existing volume group to remove")
+		}
+
+		for _, pv := range vg.physicalVolumes {
+			// Remove existing PV if any
+			if err := i.Runner.Run(ctx, "pvremove", "-ff", pv); err != nil {
+				i.Logger.Info("No existing physical volume to remove")
+			}
+
+			// Create PV
+			if err := i.Runner.Run(ctx, "pvcreate", "-ff", pv); err != nil {
+				return fmt.Errorf("failed to create physical volume: %v", err)
+			}
+		}
+
+		// Create VG spanning all of its PVs
+		args := append([]string{vg.name}, vg.physicalVolumes...)
+		if err := i.Runner.Run(ctx, "vgcreate", args...); err != nil {
+			return fmt.Errorf("failed to create volume group: %v", err)
+		}
+		i.trackVolumeGroup(vg.name)
+
+		if err := i.createLogicalVolumes(ctx, vg); err != nil {
+			return err
+		}
 	}
 
-	// Create PV
-	if err := utils.RunCommand(i.Logger, "pvcreate", "-ff", pvDevice); err != nil {
-		return fmt.Errorf("failed to create physical volume: %v", err)
+	return i.settleDevices(ctx)
+}
+
+// reuseVolumeGroup activates an existing volume group and recreates its
+// logical volumes, except those whose data is kept.
+func (i *Installer) reuseVolumeGroup(ctx context.Context, vg volumeGroupLayout) error {
+	i.Logger.Info("Keeping existing volume group: %s", vg.name)
+
+	if err := i.Runner.Run(ctx, "vgchange", "-ay", vg.name); err != nil {
+		return fmt.Errorf("failed to activate volume group: %v", err)
 	}
+	i.trackVolumeGroup(vg.name)
 
-	// Create VG
-	if err := utils.RunCommand(i.Logger, "vgcreate", lvmPartition.VolumeGroup, pvDevice); err != nil {
-		return fmt.Errorf("failed to create volume group: %v", err)
+	for _, lv := range vg.logicalVolumes {
+		if lv.Preserved() {
+			continue
+		}
+		if err := i.Runner.Run(ctx, "lvremove", "-f", vg.name+"/"+lv.Name); err != nil {
+			i.Logger.Info("No existing logical volume to remove")
+		}
 	}
 
-	// Create LVs
-	for _, lv := range lvmPartition.LogicalVolumes {
-		if err := utils.RunCommand(i.Logger, "lvcreate", "-y", "-L", lv.Size,
-			"-n", lv.Name, lvmPartition.VolumeGroup); err != nil {
+	return i.createLogicalVolumes(ctx, vg)
+}
+
+// createLogicalVolumes creates the logical volumes of vg whose data is not
+// kept.
+func (i *Installer) createLogicalVolumes(ctx context.Context, vg volumeGroupLayout) error {
+	var freeTaken uint64
+	for _, lv := range creationOrder(vg.logicalVolumes) {
+		if lv.Preserved() {
+			continue
+		}
+
+		sizeArgs, err := i.lvcreateSize(ctx, vg.name, lv, freeTaken)
+		if err != nil {
+			return err
+		}
+		if size, err := config.ParseSizeSpec(lv.Size); err == nil && size.Kind == config.SizePercentFree {
+			freeTaken += size.Percent
+		}
+
+		args := append([]string{"-y"}, sizeArgs...)
+		if err := i.Runner.Run(ctx, "lvcreate", append(args, "-n", lv.Name, vg.name)...); err != nil {
 			return fmt.Errorf("failed to create logical volume: %v", err)
 		}
 	}
@@ -129,32 +220,68 @@ func (i *Installer) setupLVM() error {
 	return nil
 }
 
-func (i *Installer) createFilesystems() error {
-	i.Logger.Info("Creating filesystems")
+// activateVolumeGroups activates every volume group, which teardown
+// deactivates between runs.
+func (i *Installer) activateVolumeGroups(ctx context.Context) error {
+	for _, vg := range i.layout().volumeGroups {
+		if err := i.Runner.Run(ctx, "vgchange", "-ay", vg.name); err != nil {
+			return fmt.Errorf("failed to activate volume group: %v", err)
+		}
+		i.trackVolumeGroup(vg.name)
+	}
 
-	// Create filesystems for regular partitions
-	for idx, partition := range i.Config.Storage.Partitions {
-		if partition.Filesystem == "" || partition.Type == config.PartitionTypeBiosBoot {
-			continue
+	return i.settleDevices(ctx)
+}
+
+// blockDevices lists the devices that have to exist to satisfy req: the
+// partitions of every disk, then the RAID arrays, the LUKS mappings and the
+// logical volumes.
+func (i *Installer) blockDevices(req requirement) []string {
+	l := i.layout()
+
+	var devices []string
+	for _, disk := range l.disks {
+		for _, partition := range disk.partitions {
+			devices = append(devices, partition.path)
 		}
+	}
 
-		if partition.Type != config.PartitionTypeLvmPV {
-			device := fmt.Sprintf("%s%d", i.Config.Storage.Devices[0], idx+1)
-			if err := createFilesystem(i.Logger, partition.Filesystem, device); err != nil {
-				return err
+	if req >= requiresArrays {
+		for _, array := range l.raidArrays {
+			devices = append(devices, array.path)
+		}
+	}
+
+	if req >= requiresMappings {
+		for _, enc := range l.encrypted {
+			devices = append(devices, enc.path)
+		}
+	}
+
+	if req >= requiresVolumes {
+		for _, vg := range l.volumeGroups {
+			for _, lv := range vg.logicalVolumes {
+				devices = append(devices, logicalVolumePath(vg.name, lv.Name))
 			}
 		}
 	}
 
-	// Create filesystems for logical volumes
-	for _, partition := range i.Config.Storage.Partitions {
-		if partition.Type != config.PartitionTypeLvmPV {
+	return devices
+}
+
+func (i *Installer) createFilesystems(ctx context.Context) error {
+	i.Logger.Info("Creating filesystems")
+
+	for _, fs := range i.layout().filesystems {
+		if fs.Preserved() {
+			i.Logger.Info("Keeping existing filesystem on %s", fs.device)
 			continue
 		}
-
-		for _, lv := range partition.LogicalVolumes {
-			device := fmt.Sprintf("/dev/%s/%s", partition.VolumeGroup, lv.Name)
-			if err := createFilesystem(i.Logger, lv.Filesystem, device); err != nil {
+		if err := i.createFilesystem(ctx, fs); err != nil {
+			return err
+		}
+		if len(fs.Subvolumes) > 0 {
+			if err := i.createSubvolumes(ctx, fs); err != nil {
 				return err
 			}
 		}
@@ -163,75 +290,98 @@ func (i *Installer) createFilesystems() error {
 	return nil
 }
 
-func createFilesystem(logger *utils.Logger, fsType, device string) error {
+func (i *Installer) createFilesystem(ctx context.Context, fs filesystem) error {
+	if fs.Filesystem == "swap" {
+		return i.createSwap(ctx, fs)
+	}
+
 	var args []string
-	switch fsType {
+	switch fs.Filesystem {
 	case "vfat":
-		args = []string{"-F32", device}
-	default:
-		args = []string{device}
+		args = []string{"-F32"}
+	case "btrfs":
+		// mkfs.btrfs refuses to overwrite an existing filesystem
+		args = []string{"-f"}
 	}
 
-	if err := utils.RunCommand(logger, "mkfs."+fsType, args...); err != nil {
+	if fs.Label != "" {
+		// mkfs.vfat takes the volume name with -n
+		flag := "-L"
+		if fs.Filesystem == "vfat" {
+			flag = "-n"
+		}
+		args = append(args, flag, fs.Label)
+	}
+
+	args = append(args, fs.MkfsOptions...)
+	args = append(args, fs.device)
+
+	if err := i.Runner.Run(ctx, "mkfs."+fs.Filesystem, args...); err != nil {
 		return fmt.Errorf("failed to create filesystem: %v", err)
 	}
 	return nil
 }
 
-func (i *Installer) mountFilesystems() error {
-	i.Logger.Info("Mounting filesystems")
+func (i *Installer) createSwap(ctx context.Context, fs filesystem) error {
+	var args []string
+	if fs.Label != "" {
+		args = append(args, "-L", fs.Label)
+	}
+	args = append(args, fs.MkfsOptions...)
+	args = append(args, fs.device)
 
-	// Collect mount points
-	var mounts []struct {
-		device     string
-		mountPoint string
+	if err := i.Runner.Run(ctx, "mkswap", args...); err != nil {
+		return fmt.Errorf("failed to create swap space: %v", err)
 	}
+	return nil
+}
 
-	// Add regular partitions
-	for idx, partition := range i.Config.Storage.Partitions {
-		if partition.MountPoint != "" && partition.Type != config.PartitionTypeLvmPV {
-			mounts = append(mounts, struct {
-				device     string
-				mountPoint string
-			}{
-				device:     fmt.Sprintf("%s%d", i.Config.Storage.Devices[0], idx+1),
-				mountPoint: partition.MountPoint,
-			})
-		}
+// createSubvolumes creates the subvolumes of a btrfs filesystem. The
+// top-level volume is mounted at the installation mount point meanwhile,
+// which is still unused before the mount phase.
+func (i *Installer) createSubvolumes(ctx context.Context, fs filesystem) error {
+	target := i.Config.Installation.MountPoint
+	if err := i.Runner.MkdirAll(ctx, target, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point directory: %v", err)
 	}
 
-	// Add logical volumes
-	for _, partition := range i.Config.Storage.Partitions {
-		if partition.Type != config.PartitionTypeLvmPV {
-			continue
-		}
+	if err := i.Runner.Run(ctx, "mount", "-t", "btrfs", "-o", "subvolid=5", fs.device, target); err != nil {
+		return fmt.Errorf("failed to mount btrfs top-level volume: %v", err)
+	}
+	i.trackMount(target)
 
-		for _, lv := range partition.LogicalVolumes {
-			mounts = append(mounts, struct {
-				device     string
-				mountPoint string
-			}{
-				device:     fmt.Sprintf("/dev/%s/%s", partition.VolumeGroup, lv.Name),
-				mountPoint: lv.MountPoint,
-			})
+	for _, sub := range fs.Subvolumes {
+		if err := i.Runner.Run(ctx, "btrfs", "subvolume", "create", filepath.Join(target, sub.Name)); err != nil {
+			return fmt.Errorf("failed to create subvolume %s: %v", sub.Name, err)
 		}
 	}
 
-	// Sort mounts by mount point length to ensure proper order
-	sort.Slice(mounts, func(i, j int) bool {
-		return len(mounts[i].mountPoint) < len(mounts[j].mountPoint)
-	})
+	if err := i.Runner.Run(ctx, "umount", target); err != nil {
+		return fmt.Errorf("failed to unmount btrfs top-level volume: %v", err)
+	}
+	i.untrackMount(target)
 
-	// Mount filesystems
-	for _, mount := range mounts {
+	return nil
+}
+
+func (i *Installer) mountFilesystems(ctx context.Context) error {
+	i.Logger.Info("Mounting filesystems")
+
+	// Mount filesystems and subvolumes, parents first
+	for _, mount := range i.layout().mounts() {
 		mountPoint := filepath.Join(i.Config.Installation.MountPoint, mount.mountPoint)
-		if err := os.MkdirAll(mountPoint, 0755); err != nil {
+		if err := i.Runner.MkdirAll(ctx, mountPoint, 0755); err != nil {
 			return fmt.Errorf("failed to create mount point directory: %v", err)
 		}
 
-		if err := utils.RunCommand(i.Logger, "mount", mount.device, mountPoint); err != nil {
+		args := []string{mount.device, mountPoint}
+		if len(mount.options) > 0 {
+			args = append([]string{"-o", strings.Join(mount.options, ",")}, args...)
+		}
+		if err := i.Runner.Run(ctx, "mount", args...); err != nil {
 			return fmt.Errorf("failed to mount filesystem: %v", err)
 		}
+		i.trackMount(mountPoint)
 	}
 
 	return nil
@@ -0,0 +1,110 @@
+package installer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPartitionMsdos(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage string
+		want    string
+	}{
+		{
+			name: "primary partitions",
+			storage: `disks:
+  - device: /dev/sda
+    table: msdos
+    partitions:
+      - {type: linux, size: 512M, bootable: true}
+      - {type: linux_swap, size: 2G}
+      - {type: lvm_pv, size: remaining, volume_group: vg0}
+`,
+			want: "label: dos\n" +
+				"size=512M, type=83, bootable\n" +
+				"size=2G, type=82\n" +
+				"type=8e\n",
+		},
+		{
+			name: "logical partitions",
+			storage: `disks:
+  - device: /dev/sda
+    table: msdos
+    partitions:
+      - {type: linux, size: 1G, bootable: true}
+      - {type: linux, size: 10G}
+      - {type: linux_swap, size: 2G}
+      - {type: linux, size: 5G}
+      - {type: raid, size: remaining, raid_array: md0}
+`,
+			want: "label: dos\n" +
+				"size=1G, type=83, bootable\n" +
+				"size=10G, type=83\n" +
+				"size=2G, type=82\n" +
+				"type=5\n" +
+				"size=5G, type=83\n" +
+				"type=fd\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, runner := newTestInstaller(t, tt.storage)
+			if err := i.partitionDevice(context.Background(), i.layout().disks[0]); err != nil {
+				t.Fatalf("partitionDevice() failed: %v", err)
+			}
+
+			want := []string{"wipefs -a /dev/sda", "sfdisk /dev/sda", "udevadm settle"}
+			if got := commandLines(runner); !reflect.DeepEqual(got, want) {
+				t.Fatalf("commands = %q, want %q", got, want)
+			}
+			if got := runner.Operations[1].Input; got != tt.want {
+				t.Errorf("sfdisk script =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartitionGPT(t *testing.T) {
+	i, runner := newTestInstaller(t, `disks:
+  - device: /dev/nvme0n1
+    partitions:
+      - {type: efi_system, size: 512M, partition_label: ESP}
+      - type: linux
+        size: remaining
+        partition_uuid: 0b4f7d35-2c53-4b4c-9d1e-5e0e9d3c8a11
+        attributes: [no_auto, 48]
+`)
+	if err := i.partitionDevice(context.Background(), i.layout().disks[0]); err != nil {
+		t.Fatalf("partitionDevice() failed: %v", err)
+	}
+
+	want := []string{
+		"sgdisk -Z -o /dev/nvme0n1",
+		"sgdisk /dev/nvme0n1" +
+			" -n 1::+512M -t 1:C12A7328-F81F-11D2-BA4B-00A0C93EC93B -c 1:ESP" +
+			" -n 2::0 -t 2:0FC63DAF-8483-4772-8E79-3D69D8477DE4 -u 2:0b4f7d35-2c53-4b4c-9d1e-5e0e9d3c8a11" +
+			" -A 2:set:63 -A 2:set:48",
+		"udevadm settle",
+	}
+	if got := commandLines(runner); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestPartitionPreservedDisk(t *testing.T) {
+	i, runner := newTestInstaller(t, `disks:
+  - device: /dev/sda
+    preserve: true
+    partitions:
+      - {type: linux, filesystem: ext4, mount_point: /srv, format: false}
+`)
+	if err := i.partitionDevice(context.Background(), i.layout().disks[0]); err != nil {
+		t.Fatalf("partitionDevice() failed: %v", err)
+	}
+	if got := commandLines(runner); len(got) != 0 {
+		t.Errorf("commands = %q, want none", got)
+	}
+}
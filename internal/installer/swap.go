@@ -0,0 +1,139 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+const defaultZramSize = "min(ram / 2, 4096)"
+
+// setupSwap creates the swap file, configures zram and records the swap
+// space to resume from after hibernation. Swap partitions and logical
+// volumes are set up with the other filesystems.
+func (i *Installer) setupSwap(ctx context.Context) error {
+	swap := i.Config.Storage.Swap
+	resume := i.resumeDevice()
+	if (swap == nil || (swap.File == nil && swap.Zram == nil)) && resume == "" {
+		return nil
+	}
+
+	i.Logger.Info("Setting up swap")
+
+	if swap != nil && swap.File != nil {
+		if err := i.createSwapFile(ctx); err != nil {
+			return err
+		}
+	}
+
+	if swap != nil && swap.Zram != nil {
+		if err := i.configureZram(ctx); err != nil {
+			return err
+		}
+	}
+
+	if resume != "" {
+		if err := i.configureResume(ctx, resume); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Installer) createSwapFile(ctx context.Context) error {
+	file := i.Config.Storage.Swap.File
+	path := filepath.Join(i.Config.Installation.MountPoint, file.Path)
+	size, err := config.ParseSize(file.Size)
+	if err != nil {
+		return fmt.Errorf("invalid swap file size: %v", err)
+	}
+
+	if i.succeeds(ctx, "test", "-e", path) {
+		i.Logger.Info("Swap file %s already exists", file.Path)
+		return nil
+	}
+
+	// Swap files on btrfs must not be copy-on-write, which mkswapfile
+	// takes care of
+	if m, ok := i.layout().mountFor(file.Path); ok && m.fsType == "btrfs" {
+		if err := i.Runner.Run(ctx, "btrfs", "filesystem", "mkswapfile", "--size", config.FormatSize(size), path); err != nil {
+			return fmt.Errorf("failed to create swap file: %v", err)
+		}
+		return nil
+	}
+
+	if err := i.Runner.Run(ctx, "fallocate", "-l", config.FormatSize(size), path); err != nil {
+		return fmt.Errorf("failed to allocate swap file: %v", err)
+	}
+	if err := i.Runner.Run(ctx, "chmod", "0600", path); err != nil {
+		return fmt.Errorf("failed to set swap file permissions: %v", err)
+	}
+	if err := i.Runner.Run(ctx, "mkswap", path); err != nil {
+		return fmt.Errorf("failed to create swap space: %v", err)
+	}
+
+	return nil
+}
+
+func (i *Installer) configureZram(ctx context.Context) error {
+	zram := i.Config.Storage.Swap.Zram
+
+	size := zram.Size
+	if size == "" {
+		size = defaultZramSize
+	}
+
+	var b strings.Builder
+	b.WriteString("[zram0]\n")
+	fmt.Fprintf(&b, "zram-size = %s\n", size)
+	if zram.Compression != "" {
+		fmt.Fprintf(&b, "compression-algorithm = %s\n", zram.Compression)
+	}
+
+	confPath := filepath.Join(i.Config.Installation.MountPoint, "etc/systemd/zram-generator.conf")
+	if err := i.Runner.WriteFile(ctx, confPath, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write zram-generator configuration: %v", err)
+	}
+
+	return nil
+}
+
+// configureResume points the initramfs and the kernel command line at the
+// swap space device to resume from.
+func (i *Installer) configureResume(ctx context.Context, device string) error {
+	uuid, err := i.deviceUUID(ctx, device)
+	if err != nil {
+		return err
+	}
+
+	target := i.Config.Installation.MountPoint
+	initramfsConf := filepath.Join(target, "etc/initramfs-tools/conf.d/resume")
+	if err := i.Runner.WriteFile(ctx, initramfsConf, []byte(fmt.Sprintf("RESUME=UUID=%s\n", uuid)), 0644); err != nil {
+		return fmt.Errorf("failed to write initramfs resume configuration: %v", err)
+	}
+
+	grubConf := filepath.Join(target, "etc/default/grub.d/resume.cfg")
+	content := fmt.Sprintf("GRUB_CMDLINE_LINUX=\"$GRUB_CMDLINE_LINUX resume=UUID=%s\"\n", uuid)
+	if err := i.Runner.MkdirAll(ctx, filepath.Dir(grubConf), 0755); err != nil {
+		return fmt.Errorf("failed to create GRUB configuration directory: %v", err)
+	}
+	if err := i.Runner.WriteFile(ctx, grubConf, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write GRUB resume configuration: %v", err)
+	}
+
+	return nil
+}
+
+// resumeDevice returns the swap device marked for resume, if any.
+func (i *Installer) resumeDevice() string {
+	for _, fs := range i.layout().filesystems {
+		if fs.Filesystem == "swap" && fs.Resume {
+			return fs.device
+		}
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// cleanup tracks the host state changed by the installer so it can be
+// undone when the installation finishes or fails.
+type cleanup struct {
+	mounts       []string
+	volumeGroups []string
+	mappings     []string
+	raidArrays   []string
+}
+
+func (i *Installer) trackMount(target string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cleanup.mounts = append(i.cleanup.mounts, target)
+}
+
+// untrackMount forgets the latest mount of target once it has been
+// unmounted again.
+func (i *Installer) untrackMount(target string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for idx := len(i.cleanup.mounts) - 1; idx >= 0; idx-- {
+		if i.cleanup.mounts[idx] == target {
+			i.cleanup.mounts = append(i.cleanup.mounts[:idx], i.cleanup.mounts[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (i *Installer) trackVolumeGroup(name string) {
+	i.track(&i.cleanup.volumeGroups, name)
+}
+
+func (i *Installer) trackMapping(name string) {
+	i.track(&i.cleanup.mappings, name)
+}
+
+func (i *Installer) trackRaidArray(path string) {
+	i.track(&i.cleanup.raidArrays, path)
+}
+
+func (i *Installer) mappingTracked(name string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return contains(i.cleanup.mappings, name)
+}
+
+func (i *Installer) raidArrayTracked(path string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return contains(i.cleanup.raidArrays, path)
+}
+
+// track adds name to list unless it is already there.
+func (i *Installer) track(list *[]string, name string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !contains(*list, name) {
+		*list = append(*list, name)
+	}
+}
+
+// Teardown unmounts everything the installer mounted, in reverse order,
+// deactivates the volume groups it activated, and closes the LUKS mappings
+// and stops the RAID arrays beneath them. It keeps going after a failure so
+// as much as possible is released, and can be called again to retry
+// whatever is left.
+func (i *Installer) Teardown(ctx context.Context) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(i.cleanup.mounts) == 0 && len(i.cleanup.volumeGroups) == 0 &&
+		len(i.cleanup.mappings) == 0 && len(i.cleanup.raidArrays) == 0 {
+		return nil
+	}
+
+	i.Logger.Info("Tearing down installation target")
+
+	var failed []string
+	var remaining cleanup
+
+	for idx := len(i.cleanup.mounts) - 1; idx >= 0; idx-- {
+		target := i.cleanup.mounts[idx]
+		if err := i.Runner.Run(ctx, "umount", target); err != nil {
+			i.Logger.Error("Failed to unmount %s: %v", target, err)
+			failed = append(failed, target)
+			remaining.mounts = append([]string{target}, remaining.mounts...)
+		}
+	}
+
+	for _, vg := range i.cleanup.volumeGroups {
+		if err := i.Runner.Run(ctx, "vgchange", "-an", vg); err != nil {
+			i.Logger.Error("Failed to deactivate volume group %s: %v", vg, err)
+			failed = append(failed, vg)
+			remaining.volumeGroups = append(remaining.volumeGroups, vg)
+		}
+	}
+
+	for idx := len(i.cleanup.mappings) - 1; idx >= 0; idx-- {
+		name := i.cleanup.mappings[idx]
+		if err := i.Runner.Run(ctx, "cryptsetup", "close", name); err != nil {
+			i.Logger.Error("Failed to close mapping %s: %v", name, err)
+			failed = append(failed, name)
+			remaining.mappings = append([]string{name}, remaining.mappings...)
+		}
+	}
+
+	for idx := len(i.cleanup.raidArrays) - 1; idx >= 0; idx-- {
+		array := i.cleanup.raidArrays[idx]
+		if err := i.Runner.Run(ctx, "mdadm", "--stop", array); err != nil {
+			i.Logger.Error("Failed to stop RAID array %s: %v", array, err)
+			failed = append(failed, array)
+			remaining.raidArrays = append([]string{array}, remaining.raidArrays...)
+		}
+	}
+
+	i.cleanup = remaining
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to release %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
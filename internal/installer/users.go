@@ -0,0 +1,226 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+func (i *Installer) configureAccounts(ctx context.Context) error {
+	if err := i.configureUsers(ctx); err != nil {
+		return err
+	}
+
+	return i.configureRoot(ctx)
+}
+
+// configureUsers creates the groups and users. Groups and users that exist
+// already, e.g. when resuming, are kept; users still get their password,
+// groups and keys.
+func (i *Installer) configureUsers(ctx context.Context) error {
+	i.Logger.Info("Configuring users")
+
+	for _, group := range i.Config.Groups {
+		if i.succeeds(ctx, "chroot", i.Config.Installation.MountPoint, "getent", "group", group.Name) {
+			i.Logger.Info("Group %s already exists", group.Name)
+			continue
+		}
+
+		args := []string{i.Config.Installation.MountPoint, "groupadd"}
+		if group.GID != nil {
+			args = append(args, "-g", strconv.Itoa(*group.GID))
+		}
+		if group.System {
+			args = append(args, "-r")
+		}
+		args = append(args, group.Name)
+
+		if err := i.Runner.Run(ctx, "chroot", args...); err != nil {
+			return fmt.Errorf("failed to create group %s: %v", group.Name, err)
+		}
+	}
+
+	for _, user := range i.Config.Users {
+		if i.succeeds(ctx, "chroot", i.Config.Installation.MountPoint, "getent", "passwd", user.Username) {
+			i.Logger.Info("User %s already exists", user.Username)
+		} else if err := i.Runner.Run(ctx, "chroot", append([]string{i.Config.Installation.MountPoint}, useraddArgs(user, i.Config.Groups)...)...); err != nil {
+			return fmt.Errorf("failed to create user %s: %v", user.Username, err)
+		}
+
+		if err := i.setPassword(ctx, user.Username, user.Credentials); err != nil {
+			return err
+		}
+
+		for _, group := range user.Groups {
+			if err := i.Runner.Run(ctx, "chroot", i.Config.Installation.MountPoint,
+				"gpasswd", "-a", user.Username, group); err != nil {
+				return fmt.Errorf("failed to add user %s to group %s: %v", user.Username, group, err)
+			}
+		}
+
+		if err := i.writeAuthorizedKeys(ctx, user.Username, userHome(user), user.SSHAuthorizedKeys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// useraddArgs returns the useradd command line for user. A user without a
+// primary group whose name is taken by one of groups gets that group as its
+// primary group, since useradd fails to create a user group of that name.
+func useraddArgs(user config.User, groups []config.Group) []string {
+	args := []string{"useradd"}
+
+	if user.CreateHome == nil || *user.CreateHome {
+		args = append(args, "-m")
+	} else {
+		args = append(args, "-M")
+	}
+
+	shell := user.Shell
+	if shell == "" {
+		shell = "/bin/bash"
+	}
+	args = append(args, "-s", shell)
+
+	if user.Home != "" {
+		args = append(args, "-d", user.Home)
+	}
+	if user.UID != nil {
+		args = append(args, "-u", strconv.Itoa(*user.UID))
+	}
+	primaryGroup := user.PrimaryGroup
+	if primaryGroup == "" {
+		for _, group := range groups {
+			if group.Name == user.Username {
+				primaryGroup = group.Name
+			}
+		}
+	}
+	if primaryGroup != "" {
+		args = append(args, "-g", primaryGroup)
+	}
+	if user.Comment != "" {
+		args = append(args, "-c", user.Comment)
+	}
+	if user.System {
+		args = append(args, "-r")
+	}
+
+	return append(args, user.Username)
+}
+
+func userHome(user config.User) string {
+	if user.Home != "" {
+		return user.Home
+	}
+	return filepath.Join("/home", user.Username)
+}
+
+// configureRoot sets the root password and SSH keys and writes the
+// PermitRootLogin policy.
+func (i *Installer) configureRoot(ctx context.Context) error {
+	root := i.Config.Root
+	if root == nil {
+		return nil
+	}
+
+	i.Logger.Info("Configuring root account")
+
+	if err := i.setPassword(ctx, "root", root.Credentials); err != nil {
+		return err
+	}
+
+	if err := i.writeAuthorizedKeys(ctx, "root", "/root", root.SSHAuthorizedKeys); err != nil {
+		return err
+	}
+
+	if root.PermitSSHLogin != "" {
+		confDir := filepath.Join(i.Config.Installation.MountPoint, "etc/ssh/sshd_config.d")
+		if err := i.Runner.MkdirAll(ctx, confDir, 0755); err != nil {
+			return fmt.Errorf("failed to create sshd_config.d: %v", err)
+		}
+
+		content := fmt.Sprintf("PermitRootLogin %s\n", root.PermitSSHLogin)
+		if err := i.Runner.WriteFile(ctx, filepath.Join(confDir, "root-login.conf"), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write root login policy: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// setPassword applies a plaintext password or a pre-hashed crypt(3) string
+// with chpasswd, or locks the password entirely.
+func (i *Installer) setPassword(ctx context.Context, username string, creds config.Credentials) error {
+	switch {
+	case creds.Password != "":
+		if err := i.Runner.RunWithInput(ctx,
+			fmt.Sprintf("%s:%s", username, creds.Password),
+			"chroot", i.Config.Installation.MountPoint, "chpasswd"); err != nil {
+			return fmt.Errorf("failed to set password for user %s: %v", username, err)
+		}
+	case creds.PasswordHash != "":
+		if err := i.Runner.RunWithInput(ctx,
+			fmt.Sprintf("%s:%s", username, creds.PasswordHash),
+			"chroot", i.Config.Installation.MountPoint, "chpasswd", "-e"); err != nil {
+			return fmt.Errorf("failed to set password hash for user %s: %v", username, err)
+		}
+	case creds.LockPassword:
+		if err := i.Runner.Run(ctx, "chroot", i.Config.Installation.MountPoint,
+			"usermod", "-L", username); err != nil {
+			return fmt.Errorf("failed to lock password for user %s: %v", username, err)
+		}
+	}
+
+	return nil
+}
+
+// writeAuthorizedKeys installs keys as ~/.ssh/authorized_keys, owned by the
+// user with the modes sshd's StrictModes expects.
+func (i *Installer) writeAuthorizedKeys(ctx context.Context, username, home string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	sshDir := filepath.Join(home, ".ssh")
+	keysFile := filepath.Join(sshDir, "authorized_keys")
+
+	// Only .ssh is created; a missing home would otherwise be created as
+	// root-owned.
+	if err := i.Runner.Run(ctx, "chroot", i.Config.Installation.MountPoint, "test", "-d", home); err != nil {
+		return fmt.Errorf("home directory %s of user %s does not exist", home, username)
+	}
+
+	if err := i.Runner.MkdirAll(ctx, filepath.Join(i.Config.Installation.MountPoint, sshDir), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", sshDir, err)
+	}
+
+	content := strings.Join(keys, "\n") + "\n"
+	if err := i.Runner.WriteFile(ctx, filepath.Join(i.Config.Installation.MountPoint, keysFile), []byte(content), 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %v", keysFile, err)
+	}
+
+	// Ownership is resolved inside the target, where the user exists.
+	if err := i.Runner.Run(ctx, "chroot", i.Config.Installation.MountPoint,
+		"chown", "-R", username+":", sshDir); err != nil {
+		return fmt.Errorf("failed to set ownership of %s: %v", sshDir, err)
+	}
+
+	if err := i.Runner.Run(ctx, "chroot", i.Config.Installation.MountPoint,
+		"chmod", "0700", sshDir); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %v", sshDir, err)
+	}
+
+	if err := i.Runner.Run(ctx, "chroot", i.Config.Installation.MountPoint,
+		"chmod", "0600", keysFile); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %v", keysFile, err)
+	}
+
+	return nil
+}
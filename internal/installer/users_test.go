@@ -0,0 +1,137 @@
+package installer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zinrai/debinstaller-go/internal/config"
+)
+
+func TestConfigureUsers(t *testing.T) {
+	notFound := errors.New("exit status 2")
+
+	tests := []struct {
+		name     string
+		existing bool
+		want     []string
+	}{
+		{
+			name: "new",
+			want: []string{
+				"chroot /mnt/debian getent group docker",
+				"chroot /mnt/debian groupadd -r docker",
+				"chroot /mnt/debian getent passwd admin",
+				"chroot /mnt/debian useradd -m -s /bin/bash admin",
+				"chroot /mnt/debian chpasswd -e",
+				"chroot /mnt/debian gpasswd -a admin docker",
+				"chroot /mnt/debian test -d /home/admin",
+				"chroot /mnt/debian chown -R admin: /home/admin/.ssh",
+				"chroot /mnt/debian chmod 0700 /home/admin/.ssh",
+				"chroot /mnt/debian chmod 0600 /home/admin/.ssh/authorized_keys",
+			},
+		},
+		{
+			name:     "existing on resume",
+			existing: true,
+			want: []string{
+				"chroot /mnt/debian getent group docker",
+				"chroot /mnt/debian getent passwd admin",
+				"chroot /mnt/debian chpasswd -e",
+				"chroot /mnt/debian gpasswd -a admin docker",
+				"chroot /mnt/debian test -d /home/admin",
+				"chroot /mnt/debian chown -R admin: /home/admin/.ssh",
+				"chroot /mnt/debian chmod 0700 /home/admin/.ssh",
+				"chroot /mnt/debian chmod 0600 /home/admin/.ssh/authorized_keys",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, runner := newTestInstaller(t, singleDisk)
+			i.Config.Groups = []config.Group{{Name: "docker", System: true}}
+			i.Config.Users = []config.User{{
+				Username: "admin",
+				Credentials: config.Credentials{
+					PasswordHash:      "$y$j9T$salt$hash",
+					SSHAuthorizedKeys: []string{"ssh-ed25519 AAAAC3Nza deploy@laptop"},
+				},
+				Groups: []string{"docker"},
+			}}
+			if !tt.existing {
+				runner.Respond("chroot /mnt/debian getent group docker", "", notFound)
+				runner.Respond("chroot /mnt/debian getent passwd admin", "", notFound)
+			}
+
+			if err := i.configureUsers(context.Background()); err != nil {
+				t.Fatalf("configureUsers() failed: %v", err)
+			}
+			if got := commandLines(runner); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands =\n%q\nwant\n%q", got, tt.want)
+			}
+			if got := string(runner.Files["/mnt/debian/home/admin/.ssh/authorized_keys"]); got != "ssh-ed25519 AAAAC3Nza deploy@laptop\n" {
+				t.Errorf("authorized_keys = %q", got)
+			}
+		})
+	}
+}
+
+func TestWriteAuthorizedKeysWithoutHome(t *testing.T) {
+	i, runner := newTestInstaller(t, singleDisk)
+	runner.Respond("chroot /mnt/debian test -d /home/admin", "", errors.New("exit status 1"))
+
+	err := i.writeAuthorizedKeys(context.Background(), "admin", "/home/admin", []string{"ssh-ed25519 AAAAC3Nza deploy@laptop"})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("writeAuthorizedKeys() = %v, want missing home error", err)
+	}
+	for _, op := range runner.Operations[1:] {
+		t.Errorf("unexpected operation after the failed check: %+v", op)
+	}
+}
+
+func TestUseraddArgs(t *testing.T) {
+	uid, gid := 1000, 1000
+	noHome := false
+
+	tests := []struct {
+		name   string
+		user   config.User
+		groups []config.Group
+		want   string
+	}{
+		{
+			name: "defaults",
+			user: config.User{Username: "admin"},
+			want: "useradd -m -s /bin/bash admin",
+		},
+		{
+			name: "options",
+			user: config.User{Username: "backup", UID: &uid, PrimaryGroup: "nogroup", Shell: "/usr/sbin/nologin",
+				Home: "/var/backups", Comment: "Backup", System: true, CreateHome: &noHome},
+			want: "useradd -M -s /usr/sbin/nologin -d /var/backups -u 1000 -g nogroup -c Backup -r backup",
+		},
+		{
+			name:   "group named like the user",
+			user:   config.User{Username: "alice", UID: &uid},
+			groups: []config.Group{{Name: "alice", GID: &gid}},
+			want:   "useradd -m -s /bin/bash -u 1000 -g alice alice",
+		},
+		{
+			name:   "primary group overrides the group named like the user",
+			user:   config.User{Username: "alice", PrimaryGroup: "staff"},
+			groups: []config.Group{{Name: "alice", GID: &gid}},
+			want:   "useradd -m -s /bin/bash -g staff alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(useraddArgs(tt.user, tt.groups), " "); got != tt.want {
+				t.Errorf("useraddArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,22 +1,77 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// ExecRunner runs commands and file operations on the local host.
+type ExecRunner struct {
+	Logger *Logger
+}
+
+// killGracePeriod is how long a cancelled command may take to exit after
+// SIGTERM before it is killed.
+const killGracePeriod = 10 * time.Second
+
+// groupCmd is a command running in its own process group.
+type groupCmd struct {
+	*exec.Cmd
+	cancelled time.Time
+}
+
+// command prepares name to run in its own process group. When ctx is
+// cancelled the whole group receives SIGTERM, so helpers spawned by
+// debootstrap or apt-get stop too.
+func command(ctx context.Context, name string, args ...string) *groupCmd {
+	cmd := &groupCmd{Cmd: exec.CommandContext(ctx, name, args...)}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		cmd.cancelled = time.Now()
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	// Only kills the group leader; killGroup kills the rest
+	cmd.WaitDelay = killGracePeriod
+	return cmd
+}
+
+// killGroup must be called once the command has finished. If it was
+// cancelled, the processes of its group that are still running get the rest
+// of the grace period to exit and then receive SIGKILL.
+func (c *groupCmd) killGroup() {
+	if c.cancelled.IsZero() {
+		return
+	}
+
+	pgid := c.Process.Pid
+	deadline := c.cancelled.Add(killGracePeriod)
+	for time.Now().Before(deadline) && syscall.Kill(-pgid, 0) == nil {
+		time.Sleep(100 * time.Millisecond)
+	}
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+func NewExecRunner(logger *Logger) *ExecRunner {
+	return &ExecRunner{Logger: logger}
+}
+
 // Output standard output and standard error
-func RunCommand(logger *Logger, name string, args ...string) error {
-	cmdLine := fmt.Sprintf("%s %s", name, strings.Join(args, " "))
-	logger.Info("Executing command: %s", cmdLine)
+func (r *ExecRunner) Run(ctx context.Context, name string, args ...string) error {
+	cmdLine := commandLine(name, args)
+	r.Logger.Info("Executing command: %s", cmdLine)
 
-	cmd := exec.Command(name, args...)
+	cmd := command(ctx, name, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	cmd.killGroup()
+	if err != nil {
 		return fmt.Errorf("command failed: %v, command: %s", err, cmdLine)
 	}
 
@@ -24,16 +79,18 @@ func RunCommand(logger *Logger, name string, args ...string) error {
 }
 
 // Execute commands that accept standard input
-func RunCommandWithInput(logger *Logger, input string, name string, args ...string) error {
-	cmdLine := fmt.Sprintf("%s %s", name, strings.Join(args, " "))
-	logger.Info("Executing command: %s with input: %s", cmdLine, input)
+func (r *ExecRunner) RunWithInput(ctx context.Context, input string, name string, args ...string) error {
+	cmdLine := commandLine(name, args)
+	r.Logger.Info("Executing command: %s with input: %s", cmdLine, input)
 
-	cmd := exec.Command(name, args...)
+	cmd := command(ctx, name, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = strings.NewReader(input)
 
-	if err := cmd.Run(); err != nil {
+	err := cmd.Run()
+	cmd.killGroup()
+	if err != nil {
 		return fmt.Errorf("command failed: %v, command: %s", err, cmdLine)
 	}
 
@@ -41,17 +98,30 @@ func RunCommandWithInput(logger *Logger, input string, name string, args ...stri
 }
 
 // Command and returns output.
-func RunCommandWithOutput(logger *Logger, name string, args ...string) ([]byte, error) {
-	cmdLine := fmt.Sprintf("%s %s", name, strings.Join(args, " "))
-	logger.Info("Executing command: %s", cmdLine)
+func (r *ExecRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	cmdLine := commandLine(name, args)
+	r.Logger.Info("Executing command: %s", cmdLine)
 
-	cmd := exec.Command(name, args...)
+	cmd := command(ctx, name, args...)
 	cmd.Stderr = os.Stderr
 
 	output, err := cmd.Output()
+	cmd.killGroup()
 	if err != nil {
 		return nil, fmt.Errorf("command failed: %v, command: %s", err, cmdLine)
 	}
 
 	return output, nil
 }
+
+func (r *ExecRunner) MkdirAll(ctx context.Context, path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (r *ExecRunner) WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+
+func (r *ExecRunner) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
@@ -4,12 +4,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 )
 
 type Logger struct {
 	infoLogger  *log.Logger
+	warnLogger  *log.Logger
 	errorLogger *log.Logger
 	file        *os.File
+
+	mu      sync.Mutex
+	secrets []string
 }
 
 func NewLogger(filename string) *Logger {
@@ -20,19 +27,56 @@ func NewLogger(filename string) *Logger {
 
 	return &Logger{
 		infoLogger:  log.New(file, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
+		warnLogger:  log.New(file, "WARNING: ", log.Ldate|log.Ltime|log.Lshortfile),
 		errorLogger: log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
 		file:        file,
 	}
 }
 
+// AddSecret marks values as sensitive. They are replaced with "***" in every
+// message written by the logger.
+func (l *Logger) AddSecret(values ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, value := range values {
+		if value != "" {
+			l.secrets = append(l.secrets, value)
+		}
+	}
+
+	// Replace longer secrets first so a secret containing another one is
+	// not left partially visible.
+	sort.Slice(l.secrets, func(i, j int) bool {
+		return len(l.secrets[i]) > len(l.secrets[j])
+	})
+}
+
+// Redact replaces every registered secret in s with "***".
+func (l *Logger) Redact(s string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, secret := range l.secrets {
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	return s
+}
+
 func (l *Logger) Info(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
+	msg := l.Redact(fmt.Sprintf(format, v...))
 	fmt.Printf("INFO: %s\n", msg)
 	l.infoLogger.Printf("%s", msg)
 }
 
+func (l *Logger) Warn(format string, v ...interface{}) {
+	msg := l.Redact(fmt.Sprintf(format, v...))
+	fmt.Printf("WARNING: %s\n", msg)
+	l.warnLogger.Printf("%s", msg)
+}
+
 func (l *Logger) Error(format string, v ...interface{}) {
-	msg := fmt.Sprintf(format, v...)
+	msg := l.Redact(fmt.Sprintf(format, v...))
 	fmt.Printf("ERROR: %s\n", msg)
 	l.errorLogger.Printf("%s", msg)
 }
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// RecordingRunner records every operation without executing anything. It
+// backs the installer's plan mode.
+type RecordingRunner struct {
+	Operations []Operation
+}
+
+func NewRecordingRunner() *RecordingRunner {
+	return &RecordingRunner{}
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, name string, args ...string) error {
+	r.record(Operation{Kind: OperationRun, Command: append([]string{name}, args...)})
+	return nil
+}
+
+func (r *RecordingRunner) RunWithInput(ctx context.Context, input string, name string, args ...string) error {
+	r.record(Operation{Kind: OperationRun, Command: append([]string{name}, args...), Input: input})
+	return nil
+}
+
+// RunWithOutput returns a placeholder naming the command, since nothing is
+// actually executed.
+func (r *RecordingRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r.record(Operation{Kind: OperationRun, Command: append([]string{name}, args...)})
+	return []byte(fmt.Sprintf("<output of %s>\n", commandLine(name, args))), nil
+}
+
+func (r *RecordingRunner) MkdirAll(ctx context.Context, path string, perm os.FileMode) error {
+	r.record(Operation{Kind: OperationMkdir, Path: path, Perm: perm})
+	return nil
+}
+
+func (r *RecordingRunner) WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	r.record(Operation{Kind: OperationWrite, Path: path, Content: data, Perm: perm})
+	return nil
+}
+
+// ReadFile returns what the plan wrote to path last, or a placeholder for
+// the content the file may already have, so that a file that is appended to
+// shows up as an append.
+func (r *RecordingRunner) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	for idx := len(r.Operations) - 1; idx >= 0; idx-- {
+		if op := r.Operations[idx]; op.Kind == OperationWrite && op.Path == path {
+			return op.Content, nil
+		}
+	}
+	return []byte(fmt.Sprintf("<existing content of %s>\n", path)), nil
+}
+
+func (r *RecordingRunner) record(op Operation) {
+	r.Operations = append(r.Operations, op)
+}
+
+// Write prints the recorded operations in a human readable form for review.
+func (r *RecordingRunner) Write(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Installation plan (%d actions):\n", len(r.Operations))
+
+	for idx, op := range r.Operations {
+		switch op.Kind {
+		case OperationRun:
+			fmt.Fprintf(&b, "%4d. run    %s\n", idx+1, op.CommandLine())
+			if op.Input != "" {
+				// Scripts fed on stdin span several lines
+				for _, line := range strings.Split(strings.TrimRight(op.Input, "\n"), "\n") {
+					fmt.Fprintf(&b, "      stdin  %s\n", line)
+				}
+			}
+		case OperationMkdir:
+			fmt.Fprintf(&b, "%4d. mkdir  %s (%#o)\n", idx+1, op.Path, op.Perm)
+		case OperationWrite:
+			fmt.Fprintf(&b, "%4d. write  %s (%#o)\n", idx+1, op.Path, op.Perm)
+			for _, line := range strings.Split(strings.TrimRight(string(op.Content), "\n"), "\n") {
+				fmt.Fprintf(&b, "      | %s\n", line)
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// FakeResponse is the scripted result of a command run through FakeRunner.
+type FakeResponse struct {
+	Output []byte
+	Err    error
+}
+
+// FakeRunner records operations like RecordingRunner, but answers commands
+// with scripted responses and keeps written files in memory so they can be
+// read back. Responses are keyed by the full command line. Commands fail with
+// the context error once ctx is cancelled.
+type FakeRunner struct {
+	RecordingRunner
+	Responses map[string]FakeResponse
+	Files     map[string][]byte
+}
+
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		Responses: make(map[string]FakeResponse),
+		Files:     make(map[string][]byte),
+	}
+}
+
+// Respond scripts the output and error returned for a command line such as
+// "blkid -s UUID -o value /dev/sda1".
+func (r *FakeRunner) Respond(cmdLine string, output string, err error) {
+	r.Responses[cmdLine] = FakeResponse{Output: []byte(output), Err: err}
+}
+
+func (r *FakeRunner) Run(ctx context.Context, name string, args ...string) error {
+	r.RecordingRunner.Run(ctx, name, args...)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Responses[commandLine(name, args)].Err
+}
+
+func (r *FakeRunner) RunWithInput(ctx context.Context, input string, name string, args ...string) error {
+	r.RecordingRunner.RunWithInput(ctx, input, name, args...)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Responses[commandLine(name, args)].Err
+}
+
+func (r *FakeRunner) RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	r.RecordingRunner.RunWithOutput(ctx, name, args...)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	response := r.Responses[commandLine(name, args)]
+	return response.Output, response.Err
+}
+
+func (r *FakeRunner) WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error {
+	r.RecordingRunner.WriteFile(ctx, path, data, perm)
+	r.Files[path] = data
+	return nil
+}
+
+func (r *FakeRunner) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	data, ok := r.Files[path]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+	}
+	return data, nil
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordingRunnerReadFile(t *testing.T) {
+	ctx := context.Background()
+	r := NewRecordingRunner()
+
+	got, err := r.ReadFile(ctx, "/mnt/debian/etc/hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<existing content of /mnt/debian/etc/hosts>\n"; string(got) != want {
+		t.Errorf("ReadFile() = %q, want %q", got, want)
+	}
+
+	// Files written by the plan read back as written
+	r.WriteFile(ctx, "/mnt/debian/etc/hosts", []byte("first\n"), 0644)
+	r.WriteFile(ctx, "/mnt/debian/etc/hosts", []byte("second\n"), 0644)
+	if got, _ := r.ReadFile(ctx, "/mnt/debian/etc/hosts"); string(got) != "second\n" {
+		t.Errorf("ReadFile() = %q, want the last write", got)
+	}
+}
+
+func TestRecordingRunnerWrite(t *testing.T) {
+	ctx := context.Background()
+	r := NewRecordingRunner()
+	r.Run(ctx, "sgdisk", "-Z", "-o", "/dev/sda")
+	r.RunWithInput(ctx, "label: dos\ntype=83\n", "sfdisk", "/dev/sda")
+	r.MkdirAll(ctx, "/mnt/debian", 0755)
+	r.WriteFile(ctx, "/mnt/debian/etc/hostname", []byte("debian-server\n"), 0644)
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `Installation plan (4 actions):
+   1. run    sgdisk -Z -o /dev/sda
+   2. run    sfdisk /dev/sda
+      stdin  label: dos
+      stdin  type=83
+   3. mkdir  /mnt/debian (0755)
+   4. write  /mnt/debian/etc/hostname (0644)
+      | debian-server
+`
+	if got := b.String(); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFakeRunner(t *testing.T) {
+	ctx := context.Background()
+	r := NewFakeRunner()
+	r.Respond("blkid -s UUID -o value /dev/sda1", "1234\n", nil)
+	r.Respond("test -b /dev/sdb", "", errors.New("exit status 1"))
+
+	if out, err := r.RunWithOutput(ctx, "blkid", "-s", "UUID", "-o", "value", "/dev/sda1"); err != nil || string(out) != "1234\n" {
+		t.Errorf("RunWithOutput() = %q, %v", out, err)
+	}
+	if err := r.Run(ctx, "test", "-b", "/dev/sdb"); err == nil {
+		t.Error("scripted failure not returned")
+	}
+	if _, err := r.ReadFile(ctx, "/etc/missing"); !os.IsNotExist(err) {
+		t.Errorf("ReadFile() of a missing file = %v, want not exist", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := r.Run(cancelled, "true"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() after cancel = %v, want context.Canceled", err)
+	}
+	if n := len(r.Operations); n != 3 {
+		t.Errorf("recorded %d operations, want 3", n)
+	}
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// Runner performs the commands and file operations of an installation.
+// The installer never touches the host directly, so the same installation
+// logic can be executed, recorded for review or scripted in tests. Commands
+// must stop when ctx is cancelled.
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) error
+	RunWithInput(ctx context.Context, input string, name string, args ...string) error
+	RunWithOutput(ctx context.Context, name string, args ...string) ([]byte, error)
+	MkdirAll(ctx context.Context, path string, perm os.FileMode) error
+	WriteFile(ctx context.Context, path string, data []byte, perm os.FileMode) error
+	ReadFile(ctx context.Context, path string) ([]byte, error)
+}
+
+type OperationKind string
+
+const (
+	OperationRun   OperationKind = "run"
+	OperationMkdir OperationKind = "mkdir"
+	OperationWrite OperationKind = "write"
+)
+
+// Operation is a single command or file operation issued through a Runner.
+type Operation struct {
+	Kind    OperationKind
+	Command []string
+	Input   string
+	Path    string
+	Content []byte
+	Perm    os.FileMode
+}
+
+// CommandLine returns the command of a run operation as a single string.
+func (op Operation) CommandLine() string {
+	return strings.Join(op.Command, " ")
+}
+
+func commandLine(name string, args []string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}